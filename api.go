@@ -0,0 +1,727 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/viewscreen/viewscreen/internal/downloader"
+	"github.com/viewscreen/viewscreen/internal/probe"
+	"github.com/viewscreen/viewscreen/internal/transcoder"
+)
+
+// The JSON API lives under /api. Requests and responses are JSON, errors are
+// returned as {"error": "..."} with a matching status code, and nothing redirects.
+// The API is described in static/openapi.json.
+
+type APIDownload struct {
+	ID        string    `json:"id"`
+	Created   time.Time `json:"created"`
+	Size      int64     `json:"size"`
+	Shared    bool      `json:"shared"`
+	Watched   bool      `json:"watched"`
+	Uploading bool      `json:"uploading"`
+	Files     []APIFile `json:"files,omitempty"`
+}
+
+type APIFile struct {
+	ID          string    `json:"id"`
+	Size        int64     `json:"size"`
+	Viewable    bool      `json:"viewable"`
+	Convertible bool      `json:"convertible"`
+	Transcoding bool      `json:"transcoding"`
+	URL         string    `json:"url"`
+	HLS         string    `json:"hls,omitempty"` // master playlist, if the file has been packaged as HLS
+	Media       *APIMedia `json:"media,omitempty"`
+}
+
+type APIMedia struct {
+	Duration       float64  `json:"duration"`
+	Resolution     string   `json:"resolution,omitempty"`
+	VideoCodec     string   `json:"video_codec,omitempty"`
+	AudioCodecs    []string `json:"audio_codecs"`
+	AudioLanguages []string `json:"audio_languages"`
+	Subtitles      []string `json:"subtitles"`
+	BitRate        int64    `json:"bit_rate"`
+}
+
+type APITransfer struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	URL        string    `json:"url"`
+	State      string    `json:"state"`
+	Created    time.Time `json:"created"`
+	Started    time.Time `json:"started"`
+	Size       int64     `json:"size"`
+	Downloaded int64     `json:"downloaded"`
+	Uploaded   int64     `json:"uploaded"`
+	Ratio      float64   `json:"ratio"`
+	Priority   int       `json:"priority"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type APITranscode struct {
+	Download string  `json:"download"`
+	File     string  `json:"file"`
+	Profile  string  `json:"profile"`
+	State    string  `json:"state"`
+	Mode     string  `json:"mode,omitempty"`
+	Duration float64 `json:"duration,omitempty"` // seconds
+	Done     float64 `json:"done,omitempty"`     // seconds
+	Percent  float64 `json:"percent,omitempty"`
+	Speed    float64 `json:"speed,omitempty"`
+	ETA      float64 `json:"eta,omitempty"` // seconds
+}
+
+type APITranscodeRecord struct {
+	Download string    `json:"download"`
+	File     string    `json:"file"`
+	Output   string    `json:"output,omitempty"`
+	Profile  string    `json:"profile,omitempty"`
+	State    string    `json:"state"`
+	Mode     string    `json:"mode,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Error    string    `json:"error,omitempty"`
+	Log      string    `json:"log,omitempty"`
+}
+
+// APIProfile is a transcoding profile and its ID, which is used to pick it.
+type APIProfile struct {
+	ID string `json:"id"`
+	transcoder.Profile
+	Builtin bool `json:"builtin"`
+	Default bool `json:"default"`
+}
+
+type APIFriend struct {
+	ID string `json:"id"`
+}
+
+// APISettings is a partial settings update; missing fields are left unchanged.
+type APISettings struct {
+	Ratio               *float64 `json:"ratio"`
+	SeedMinTime         *int     `json:"seed_min_time"`
+	SeedMaxTime         *int     `json:"seed_max_time"`
+	SeedIdle            *int     `json:"seed_idle_time"`
+	SelectFiles         *bool    `json:"select_files"`
+	AutoConvert         *bool    `json:"auto_convert"`
+	KeepOriginals       *bool    `json:"keep_originals"`
+	UploadSpeed         *int64   `json:"upload_speed"`
+	DownloadSpeed       *int64   `json:"download_speed"`
+	Turtle              *bool    `json:"turtle"`
+	TurtleUploadSpeed   *int64   `json:"turtle_upload_speed"`
+	TurtleDownloadSpeed *int64   `json:"turtle_download_speed"`
+	MinFree             *int64   `json:"min_free"`
+	Retention           *bool    `json:"retention"`
+	ArchiveDir          *string  `json:"archive_dir"`
+	TrashDays           *int     `json:"trash_days"`
+}
+
+func apiRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, Prefix("/api/"))
+}
+
+func apiError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		logger.Error(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func apiStatus(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	JSON(w, data)
+}
+
+func apiDecode(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(io.LimitReader(r.Body, httpReadLimit)).Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON body: %s", err)
+	}
+	return nil
+}
+
+func newAPIDownload(r *http.Request, dl Download, files bool) APIDownload {
+	d := APIDownload{
+		ID:        dl.ID,
+		Created:   dl.Created,
+		Size:      dl.Size(),
+		Shared:    dl.Shared(),
+		Watched:   dl.Watched(),
+		Uploading: dl.Uploading(),
+	}
+	if !files {
+		return d
+	}
+	d.Files = []APIFile{}
+	for _, f := range dl.Files(false) {
+		af := APIFile{
+			ID:          f.ID,
+			Size:        f.Info.Size(),
+			Viewable:    f.Viewable(),
+			Convertible: f.Convertible(),
+			Transcoding: f.Transcoding(),
+			URL:         BaseURL(r) + "/downloads/stream/" + dl.ID + "/" + f.ID,
+			Media:       newAPIMedia(f.Probe()),
+		}
+		if f.HLS() {
+			af.HLS = BaseURL(r) + "/downloads/hls/" + dl.ID + "/" + f.ID + "/" + transcoder.HLSPlaylist
+		}
+		d.Files = append(d.Files, af)
+	}
+	return d
+}
+
+func newAPIMedia(info *probe.Info) *APIMedia {
+	if info == nil {
+		return nil
+	}
+	m := &APIMedia{
+		Duration:       info.Duration().Seconds(),
+		Resolution:     info.Resolution(),
+		AudioCodecs:    append([]string{}, info.AudioCodecs()...),
+		AudioLanguages: append([]string{}, info.AudioLanguages()...),
+		Subtitles:      []string{},
+		BitRate:        info.BitRate(),
+	}
+	if v := info.Video(); v != nil {
+		m.VideoCodec = v.CodecName
+	}
+	for _, s := range info.Subtitles() {
+		m.Subtitles = append(m.Subtitles, s.String())
+	}
+	return m
+}
+
+func newAPITransfer(t downloader.Transfer) APITransfer {
+	at := APITransfer{
+		ID:         t.ID,
+		Name:       t.String(),
+		URL:        t.URL.String(),
+		State:      t.State(),
+		Created:    t.Created,
+		Started:    t.Started,
+		Size:       t.TotalSize(),
+		Downloaded: t.DownloadedBytes(),
+		Uploaded:   t.UploadedBytes(),
+		Ratio:      t.Ratio(),
+		Priority:   t.Priority,
+	}
+	if t.Error != nil {
+		at.Error = t.Error.Error()
+	}
+	return at
+}
+
+//
+// Library
+//
+
+func apiDownloads(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	query := strings.ToLower(strings.TrimSpace(r.FormValue("q")))
+	sortby := strings.ToLower(strings.TrimSpace(r.FormValue("sort")))
+
+	dls, err := ListDownloads()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	switch sortby {
+	case "name":
+	case "duration":
+		sortDuration(dls)
+	default:
+		sort.Slice(dls, func(i, j int) bool { return dls[i].Created.After(dls[j].Created) })
+	}
+
+	downloads := []APIDownload{}
+	for _, dl := range dls {
+		if query != "" && !strings.Contains(strings.ToLower(dl.ID), query) {
+			continue
+		}
+		downloads = append(downloads, newAPIDownload(r, dl, false))
+	}
+	JSON(w, downloads)
+}
+
+func apiDownload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	dl, err := FindDownload(ps.ByName("id"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	JSON(w, newAPIDownload(r, dl, true))
+}
+
+func apiDownloadRemove(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	dl, err := FindDownload(ps.ByName("id"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	item, err := TrashDownload(dl, ps.ByName("user"))
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	JSON(w, item)
+}
+
+func apiDownloadShare(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	dl, err := FindDownload(ps.ByName("id"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	if r.Method == "DELETE" {
+		err = dl.Unshare()
+	} else {
+		err = dl.Share()
+	}
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	JSON(w, newAPIDownload(r, dl, false))
+}
+
+//
+// Transfers
+//
+
+func apiTransfers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	transfers := []APITransfer{}
+	for _, t := range ListTransfers() {
+		transfers = append(transfers, newAPITransfer(t))
+	}
+	for _, t := range ListTransfersPending() {
+		transfers = append(transfers, newAPITransfer(t))
+	}
+	JSON(w, transfers)
+}
+
+// apiTransferAdd starts a transfer from {"url": "..."} or an uploaded "torrent" file.
+func apiTransferAdd(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	f, rawurl, err := apiTransferSource(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	var t downloader.Transfer
+	if f != nil {
+		defer f.Close()
+		t, err = AddTorrentTransfer(f, ps.ByName("user"))
+	} else {
+		t, err = AddTransfer(rawurl, ps.ByName("user"))
+	}
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	apiStatus(w, http.StatusCreated, newAPITransfer(t))
+}
+
+// apiTransferSource returns the .torrent file uploaded in a multipart request,
+// or otherwise the magnet or HTTP URL in the JSON body, whatever the content type says.
+func apiTransferSource(r *http.Request) (multipart.File, string, error) {
+	// Parsing a form reads the body, so it's only done for uploads.
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		f, _, err := r.FormFile("torrent")
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid torrent upload: %s", err)
+		}
+		return f, "", nil
+	}
+
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := apiDecode(r, &req); err != nil {
+		return nil, "", err
+	}
+	u, err := downloader.ParseURL(strings.TrimSpace(req.URL))
+	if err != nil {
+		return nil, "", err
+	}
+	return nil, u.String(), nil
+}
+
+func apiTransfer(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	t, err := FindTransfer(ps.ByName("id"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	JSON(w, newAPITransfer(t))
+}
+
+func apiTransferCancel(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if _, err := FindTransfer(ps.ByName("id")); err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	if err := CancelTransfer(ps.ByName("id"), ps.ByName("user")); err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiTransferPause(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	apiTransferAction(w, r, ps, PauseTransfer)
+}
+
+func apiTransferResume(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	apiTransferAction(w, r, ps, ResumeTransfer)
+}
+
+func apiTransferAction(w http.ResponseWriter, r *http.Request, ps httprouter.Params, action func(id string) error) {
+	id := ps.ByName("id")
+	if _, err := FindTransfer(id); err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	if err := action(id); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	t, err := FindTransfer(id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	JSON(w, newAPITransfer(t))
+}
+
+//
+// Transcodes
+//
+
+func apiTranscodes(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	transcodes := []APITranscode{}
+	for _, job := range ListTranscodeJobs() {
+		p := job.Progress
+		transcodes = append(transcodes, APITranscode{
+			Download: job.Download,
+			File:     job.File,
+			Profile:  job.Profile,
+			State:    job.State,
+			Mode:     job.Mode,
+			Duration: p.Duration.Seconds(),
+			Done:     p.Done.Seconds(),
+			Percent:  p.Percent(),
+			Speed:    p.Speed,
+			ETA:      p.ETA().Seconds(),
+		})
+	}
+	JSON(w, transcodes)
+}
+
+func apiTranscodeHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	records := []APITranscodeRecord{}
+	for _, record := range ListTranscodeHistory() {
+		records = append(records, APITranscodeRecord{
+			Download: record.Download,
+			File:     record.File,
+			Output:   record.Output,
+			Profile:  record.Profile,
+			State:    record.State(),
+			Mode:     record.Mode,
+			Started:  record.Started,
+			Finished: record.Finished,
+			Error:    record.Error,
+			Log:      record.Log,
+		})
+	}
+	JSON(w, records)
+}
+
+func apiTranscode(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	dl, err := FindDownload(ps.ByName("id"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	file, err := dl.FindFile(strings.TrimPrefix(ps.ByName("file"), "/"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if r.Method == "DELETE" {
+		if err := CancelTranscode(file.Path, r.FormValue("profile")); err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	cfg := config.Get()
+	profile, err := cfg.FindProfile(r.FormValue("profile"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := StartTranscode(file.Path, profile.ID()); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	apiStatus(w, http.StatusAccepted, APITranscode{Download: dl.ID, File: file.ID, Profile: profile.ID(), State: "queued"})
+}
+
+func apiProfiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cfg := config.Get()
+	def := cfg.DefaultProfile().ID()
+	profiles := []APIProfile{}
+	for _, p := range cfg.Profiles() {
+		profiles = append(profiles, APIProfile{
+			ID:      p.ID(),
+			Profile: p,
+			Builtin: p.Builtin(),
+			Default: p.ID() == def,
+		})
+	}
+	JSON(w, profiles)
+}
+
+//
+// Friends
+//
+
+func apiFriends(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	friends, err := ListFriends()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	list := []APIFriend{}
+	for _, f := range friends {
+		list = append(list, APIFriend{ID: f.ID})
+	}
+	JSON(w, list)
+}
+
+func apiFriendAdd(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req struct {
+		Host string `json:"host"`
+	}
+	if err := apiDecode(r, &req); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	host := strings.TrimSpace(req.Host)
+	if host == "" || !validFriendHost.MatchString(host) {
+		apiError(w, http.StatusBadRequest, fmt.Errorf("invalid friend host %q", host))
+		return
+	}
+	if err := AddFriend(host); err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	apiStatus(w, http.StatusCreated, APIFriend{ID: host})
+}
+
+func apiFriendRemove(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	f, err := FindFriend(ps.ByName("host"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	if err := RemoveFriend(f.ID); err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiFriendDownloads(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	f, err := FindFriend(ps.ByName("host"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	downloads := f.Downloads()
+	if f.Error != nil {
+		apiError(w, http.StatusBadGateway, f.Error)
+		return
+	}
+	if downloads == nil {
+		downloads = []FriendDownload{}
+	}
+	JSON(w, downloads)
+}
+
+func apiFriendDownload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	f, err := FindFriend(ps.ByName("host"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	endpoint := &url.URL{
+		Scheme:   "https",
+		Host:     f.ID,
+		Path:     "/viewscreen/v1/downloads/files/" + ps.ByName("dl"),
+		RawQuery: "friend=" + httpHost,
+	}
+	t, err := AddTransfer(endpoint.String(), ps.ByName("user"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	apiStatus(w, http.StatusCreated, newAPITransfer(t))
+}
+
+//
+// Settings
+//
+
+func apiSettings(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	JSON(w, config.Get())
+}
+
+func apiSettingsUpdate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req APISettings
+	if err := apiDecode(r, &req); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := applySettings(req); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	JSON(w, config.Get())
+}
+
+// validate checks the update before anything is saved.
+func (req APISettings) validate() error {
+	ints := map[string]*int{
+		"seed_min_time":  req.SeedMinTime,
+		"seed_max_time":  req.SeedMaxTime,
+		"seed_idle_time": req.SeedIdle,
+		"trash_days":     req.TrashDays,
+	}
+	for name, n := range ints {
+		if n != nil && *n < 0 {
+			return fmt.Errorf("%s can't be negative", name)
+		}
+	}
+	int64s := map[string]*int64{
+		"upload_speed":          req.UploadSpeed,
+		"download_speed":        req.DownloadSpeed,
+		"turtle_upload_speed":   req.TurtleUploadSpeed,
+		"turtle_download_speed": req.TurtleDownloadSpeed,
+		"min_free":              req.MinFree,
+	}
+	for name, n := range int64s {
+		if n != nil && *n < 0 {
+			return fmt.Errorf("%s can't be negative", name)
+		}
+	}
+	return nil
+}
+
+// applySettings saves the fields that are set, and passes them on to the downloader.
+func applySettings(req APISettings) error {
+	if err := req.validate(); err != nil {
+		return err
+	}
+	cfg := config.Get()
+
+	// The archive directory is checked when it's saved, so save it first.
+	if req.Retention != nil {
+		cfg.Retention = *req.Retention
+	}
+	if req.ArchiveDir != nil {
+		cfg.ArchiveDir = strings.TrimSpace(*req.ArchiveDir)
+	}
+	if err := config.SetRetention(cfg.Retention, cfg.ArchiveDir); err != nil {
+		return err
+	}
+
+	rules := cfg.SeedRules()
+	if req.Ratio != nil {
+		rules.Ratio = *req.Ratio
+	}
+	if req.SeedMinTime != nil {
+		rules.MinTime = time.Duration(*req.SeedMinTime) * time.Minute
+	}
+	if req.SeedMaxTime != nil {
+		rules.MaxTime = time.Duration(*req.SeedMaxTime) * time.Minute
+	}
+	if req.SeedIdle != nil {
+		rules.IdleTime = time.Duration(*req.SeedIdle) * time.Minute
+	}
+	if err := config.SetSeedRules(rules); err != nil {
+		return err
+	}
+	dler.Config.SetSeedRules(rules)
+
+	if req.SelectFiles != nil {
+		if err := config.SetSelectFiles(*req.SelectFiles); err != nil {
+			return err
+		}
+		dler.Config.SetSelectFiles(*req.SelectFiles)
+	}
+
+	if req.AutoConvert != nil {
+		cfg.AutoConvert = *req.AutoConvert
+	}
+	if req.KeepOriginals != nil {
+		cfg.KeepOriginals = *req.KeepOriginals
+	}
+	if err := config.SetAutoConvert(cfg.AutoConvert, cfg.KeepOriginals); err != nil {
+		return err
+	}
+
+	if req.UploadSpeed != nil {
+		cfg.UploadSpeed = *req.UploadSpeed
+	}
+	if req.DownloadSpeed != nil {
+		cfg.DownloadSpeed = *req.DownloadSpeed
+	}
+	if req.TurtleUploadSpeed != nil {
+		cfg.TurtleUploadSpeed = *req.TurtleUploadSpeed
+	}
+	if req.TurtleDownloadSpeed != nil {
+		cfg.TurtleDownloadSpeed = *req.TurtleDownloadSpeed
+	}
+	if err := config.SetSpeeds(cfg.UploadSpeed, cfg.DownloadSpeed, cfg.TurtleUploadSpeed, cfg.TurtleDownloadSpeed); err != nil {
+		return err
+	}
+	if req.Turtle != nil {
+		if err := config.SetTurtle(*req.Turtle); err != nil {
+			return err
+		}
+	}
+	applySpeeds()
+
+	if req.MinFree != nil {
+		if err := config.SetMinFree(*req.MinFree); err != nil {
+			return err
+		}
+		cfg = config.Get()
+		dler.Config.SetMinFree(cfg.MinFreeBytes())
+	}
+
+	if req.TrashDays != nil {
+		if err := config.SetTrashDays(*req.TrashDays); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func apiSpec(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	serveAsset(w, r, "/openapi.json")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPITransferSourceJSON(t *testing.T) {
+	// The README example: curl sends it urlencoded, other clients may send no content type.
+	body := `{"url": "magnet:?xt=urn:btih:0123456789abcdef"}`
+	for _, contentType := range []string{"", "application/x-www-form-urlencoded", "application/json"} {
+		r := httptest.NewRequest("POST", "/viewscreen/api/transfers", strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		f, rawurl, err := apiTransferSource(r)
+		if err != nil {
+			t.Fatalf("content type %q: %s", contentType, err)
+		}
+		if f != nil {
+			t.Errorf("content type %q: got a torrent file", contentType)
+		}
+		if rawurl != "magnet:?xt=urn:btih:0123456789abcdef" {
+			t.Errorf("content type %q: got URL %q", contentType, rawurl)
+		}
+	}
+}
+
+func TestAPITransferSourceInvalidJSON(t *testing.T) {
+	r := httptest.NewRequest("POST", "/viewscreen/api/transfers", strings.NewReader("url=magnet"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, _, err := apiTransferSource(r); err == nil {
+		t.Error("expected an error for a body that isn't JSON")
+	}
+}
+
+func TestAPITransferSourceInvalidURL(t *testing.T) {
+	for _, body := range []string{
+		`{}`,
+		`{"url": ""}`,
+		`{"url": "  "}`,
+		`{"url": "file:///etc/passwd"}`,
+		`{"url": "/etc/passwd"}`,
+		`{"url": "ftp://example.com/movie.mp4"}`,
+	} {
+		r := httptest.NewRequest("POST", "/viewscreen/api/transfers", strings.NewReader(body))
+		if _, _, err := apiTransferSource(r); err == nil {
+			t.Errorf("%s: expected an error", body)
+		}
+	}
+}
+
+func TestAPITransferAddInvalidURL(t *testing.T) {
+	r := httptest.NewRequest("POST", "/viewscreen/api/transfers", strings.NewReader(`{"url": ""}`))
+	w := httptest.NewRecorder()
+	apiTransferAdd(w, r, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status is %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAPITransferSourceUpload(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("torrent", "example.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("d4:infod4:name7:examplee"))
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/viewscreen/api/transfers", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	f, rawurl, err := apiTransferSource(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f == nil {
+		t.Fatal("expected the torrent file")
+	}
+	defer f.Close()
+	if rawurl != "" {
+		t.Errorf("got URL %q", rawurl)
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "d4:infod4:name7:examplee" {
+		t.Errorf("got torrent %q", b)
+	}
+}
+
+func TestAPITransferSourceMissingUpload(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("url", "magnet:?xt=urn:btih:0123456789abcdef")
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/viewscreen/api/transfers", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	if _, _, err := apiTransferSource(r); err == nil {
+		t.Error("expected an error for a multipart request without a torrent")
+	}
+}
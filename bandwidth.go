@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"time"
+)
+
+// SpeedRule limits the speeds on some days of the week, between two hours.
+// An end hour before the start hour runs past midnight into the next day.
+type SpeedRule struct {
+	ID            string         `json:"id"`
+	Days          []time.Weekday `json:"days"`
+	Start         int            `json:"start"`
+	End           int            `json:"end"`
+	UploadSpeed   int64          `json:"upload_speed"`
+	DownloadSpeed int64          `json:"download_speed"`
+}
+
+func NewSpeedRule(days []time.Weekday, start, end int, up, down int64) (SpeedRule, error) {
+	if len(days) == 0 {
+		return SpeedRule{}, fmt.Errorf("choose at least one day")
+	}
+	if start < 0 || start > 23 || end < 0 || end > 24 || start == end {
+		return SpeedRule{}, fmt.Errorf("invalid hours %d-%d", start, end)
+	}
+	if up < 0 || down < 0 {
+		return SpeedRule{}, fmt.Errorf("speeds can't be negative")
+	}
+	return SpeedRule{
+		ID:            fmt.Sprintf("%x", md5.Sum([]byte(fmt.Sprintf("%v %d %d", days, start, end)))),
+		Days:          days,
+		Start:         start,
+		End:           end,
+		UploadSpeed:   up,
+		DownloadSpeed: down,
+	}, nil
+}
+
+func (r SpeedRule) on(day time.Weekday) bool {
+	for _, d := range r.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Active returns true if the rule applies at the time.
+func (r SpeedRule) Active(now time.Time) bool {
+	day, hour := now.Weekday(), now.Hour()
+	if r.Start < r.End {
+		return r.on(day) && hour >= r.Start && hour < r.End
+	}
+	// Past midnight, the rule belongs to the day before.
+	if hour >= r.Start {
+		return r.on(day)
+	}
+	if hour < r.End {
+		return r.on((day + 6) % 7)
+	}
+	return false
+}
+
+// DayNames returns the short names of the rule's days.
+func (r SpeedRule) DayNames() string {
+	var names string
+	for i, d := range r.Days {
+		if i > 0 {
+			names += " "
+		}
+		names += d.String()[:3]
+	}
+	return names
+}
+
+// Speeds returns the upload and download speeds in megabits per second at the time,
+// and what they came from. Zero is unlimited.
+func (c *Config) Speeds(now time.Time) (int64, int64, string) {
+	if c.Turtle {
+		return c.TurtleUploadSpeed, c.TurtleDownloadSpeed, "turtle"
+	}
+	for _, rule := range c.SpeedRules {
+		if rule.Active(now) {
+			return rule.UploadSpeed, rule.DownloadSpeed, "schedule"
+		}
+	}
+	return c.UploadSpeed, c.DownloadSpeed, "normal"
+}
+
+// applySpeeds sets the downloader speeds from the settings.
+func applySpeeds() {
+	cfg := config.Get()
+	up, down, mode := cfg.Speeds(time.Now())
+	if curup, curdown := dler.Speeds(); curup == up && curdown == down {
+		return
+	}
+	logger.Infof("speed limits are now %d Mbps up %d Mbps down (%s)", up, down, mode)
+	dler.SetSpeeds(up, down)
+}
+
+// scheduleSpeeds applies the speed schedule as time passes.
+func scheduleSpeeds() {
+	for {
+		applySpeeds()
+		time.Sleep(1 * time.Minute)
+	}
+}
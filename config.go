@@ -2,10 +2,22 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/viewscreen/viewscreen/internal/downloader"
+	"github.com/viewscreen/viewscreen/internal/transcoder"
+)
+
+// Default speed limits in megabits per second.
+var (
+	defaultUploadSpeed   int64 = 100
+	defaultDownloadSpeed int64 = 200
 )
 
 type Config struct {
@@ -13,8 +25,43 @@ type Config struct {
 	filename string
 
 	// Settings
-	Ratio     float64 `json:"ratio"`
-	AcceptTOS bool    `json:"accept_tos"`
+	Ratio       float64 `json:"ratio"`
+	SeedMinTime int     `json:"seed_min_time"`  // minutes
+	SeedMaxTime int     `json:"seed_max_time"`  // minutes
+	SeedIdle    int     `json:"seed_idle_time"` // minutes
+	AcceptTOS   bool    `json:"accept_tos"`
+	SelectFiles bool    `json:"select_files"`
+
+	// Hooks run on transfer and transcode events.
+	Hooks []Hook `json:"hooks"`
+
+	// Convert finished downloads automatically.
+	AutoConvert   bool          `json:"auto_convert"`
+	KeepOriginals bool          `json:"keep_originals"`
+	ConvertRules  []ConvertRule `json:"convert_rules"`
+
+	// Transcoding profiles besides the built-in ones, and the one used by default.
+	TranscodeProfile  string               `json:"transcode_profile"`
+	TranscodeProfiles []transcoder.Profile `json:"transcode_profiles"`
+
+	// Speed limits in megabits per second; zero is unlimited.
+	UploadSpeed         int64       `json:"upload_speed"`
+	DownloadSpeed       int64       `json:"download_speed"`
+	SpeedRules          []SpeedRule `json:"speed_rules"`
+	Turtle              bool        `json:"turtle"`
+	TurtleUploadSpeed   int64       `json:"turtle_upload_speed"`
+	TurtleDownloadSpeed int64       `json:"turtle_download_speed"`
+
+	// Hold downloads when free space drops below this many gigabytes; zero never holds.
+	MinFree int64 `json:"min_free"`
+
+	// Clean up the library by rule.
+	Retention      bool            `json:"retention"`
+	ArchiveDir     string          `json:"archive_dir"`
+	RetentionRules []RetentionRule `json:"retention_rules"`
+
+	// Purge removed downloads from the trash after this many days; zero keeps them.
+	TrashDays int `json:"trash_days"`
 }
 
 func NewConfig(filename string) (*Config, error) {
@@ -26,6 +73,11 @@ func NewConfig(filename string) (*Config, error) {
 	if os.IsNotExist(err) {
 		c.Ratio = 1.5
 		c.AcceptTOS = false
+		c.UploadSpeed = defaultUploadSpeed
+		c.DownloadSpeed = defaultDownloadSpeed
+		c.TurtleUploadSpeed = 1
+		c.TurtleDownloadSpeed = 5
+		c.TrashDays = 30
 		return c, c.Save()
 	}
 	if err != nil {
@@ -36,6 +88,19 @@ func NewConfig(filename string) (*Config, error) {
 	if err := json.Unmarshal(b, c); err != nil {
 		return nil, err
 	}
+
+	// Configs from before the speed settings get the limits they had, not unlimited.
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	if _, ok := fields["upload_speed"]; !ok {
+		c.UploadSpeed = defaultUploadSpeed
+		c.DownloadSpeed = defaultDownloadSpeed
+		c.TurtleUploadSpeed = 1
+		c.TurtleDownloadSpeed = 5
+		return c, c.Save()
+	}
 	return c, nil
 }
 
@@ -44,8 +109,35 @@ func (c *Config) Get() Config {
 	defer c.RUnlock()
 
 	return Config{
-		Ratio:     c.Ratio,
-		AcceptTOS: c.AcceptTOS,
+		Ratio:       c.Ratio,
+		SeedMinTime: c.SeedMinTime,
+		SeedMaxTime: c.SeedMaxTime,
+		SeedIdle:    c.SeedIdle,
+		AcceptTOS:   c.AcceptTOS,
+		SelectFiles: c.SelectFiles,
+		Hooks:       append([]Hook(nil), c.Hooks...),
+
+		AutoConvert:   c.AutoConvert,
+		KeepOriginals: c.KeepOriginals,
+		ConvertRules:  append([]ConvertRule(nil), c.ConvertRules...),
+
+		TranscodeProfile:  c.TranscodeProfile,
+		TranscodeProfiles: append([]transcoder.Profile(nil), c.TranscodeProfiles...),
+
+		UploadSpeed:         c.UploadSpeed,
+		DownloadSpeed:       c.DownloadSpeed,
+		SpeedRules:          append([]SpeedRule(nil), c.SpeedRules...),
+		Turtle:              c.Turtle,
+		TurtleUploadSpeed:   c.TurtleUploadSpeed,
+		TurtleDownloadSpeed: c.TurtleDownloadSpeed,
+
+		MinFree: c.MinFree,
+
+		Retention:      c.Retention,
+		ArchiveDir:     c.ArchiveDir,
+		RetentionRules: append([]RetentionRule(nil), c.RetentionRules...),
+
+		TrashDays: c.TrashDays,
 	}
 }
 
@@ -56,6 +148,33 @@ func (c *Config) SetRatio(n float64) error {
 	return c.Save()
 }
 
+// SeedRules returns the default seeding rules for the downloader.
+func (c *Config) SeedRules() downloader.SeedRules {
+	return downloader.SeedRules{
+		Ratio:    c.Ratio,
+		MinTime:  time.Duration(c.SeedMinTime) * time.Minute,
+		MaxTime:  time.Duration(c.SeedMaxTime) * time.Minute,
+		IdleTime: time.Duration(c.SeedIdle) * time.Minute,
+	}
+}
+
+func (c *Config) SetSeedRules(rules downloader.SeedRules) error {
+	c.Lock()
+	c.Ratio = rules.Ratio
+	c.SeedMinTime = int(rules.MinTime / time.Minute)
+	c.SeedMaxTime = int(rules.MaxTime / time.Minute)
+	c.SeedIdle = int(rules.IdleTime / time.Minute)
+	c.Unlock()
+	return c.Save()
+}
+
+func (c *Config) SetSelectFiles(v bool) error {
+	c.Lock()
+	c.SelectFiles = v
+	c.Unlock()
+	return c.Save()
+}
+
 func (c *Config) SetAcceptTOS(v bool) error {
 	c.Lock()
 	c.AcceptTOS = v
@@ -63,6 +182,246 @@ func (c *Config) SetAcceptTOS(v bool) error {
 	return c.Save()
 }
 
+func (c *Config) SetSpeeds(up, down, turtleUp, turtleDown int64) error {
+	c.Lock()
+	c.UploadSpeed = up
+	c.DownloadSpeed = down
+	c.TurtleUploadSpeed = turtleUp
+	c.TurtleDownloadSpeed = turtleDown
+	c.Unlock()
+	return c.Save()
+}
+
+// MinFreeBytes returns the free space watermark for the downloader.
+func (c *Config) MinFreeBytes() int64 {
+	return c.MinFree * 1024 * 1024 * 1024
+}
+
+func (c *Config) SetMinFree(n int64) error {
+	c.Lock()
+	c.MinFree = n
+	c.Unlock()
+	return c.Save()
+}
+
+func (c *Config) SetTurtle(v bool) error {
+	c.Lock()
+	c.Turtle = v
+	c.Unlock()
+	return c.Save()
+}
+
+func (c *Config) AddSpeedRule(rule SpeedRule) error {
+	c.Lock()
+	for _, r := range c.SpeedRules {
+		if r.ID == rule.ID {
+			c.Unlock()
+			return fmt.Errorf("schedule already exists")
+		}
+	}
+	c.SpeedRules = append(c.SpeedRules, rule)
+	c.Unlock()
+	return c.Save()
+}
+
+func (c *Config) RemoveSpeedRule(id string) error {
+	c.Lock()
+	var rules []SpeedRule
+	for _, r := range c.SpeedRules {
+		if r.ID == id {
+			continue
+		}
+		rules = append(rules, r)
+	}
+	c.SpeedRules = rules
+	c.Unlock()
+	return c.Save()
+}
+
+func (c *Config) SetTrashDays(n int) error {
+	c.Lock()
+	c.TrashDays = n
+	c.Unlock()
+	return c.Save()
+}
+
+func (c *Config) SetRetention(enabled bool, archiveDir string) error {
+	if archiveDir != "" {
+		archiveDir = filepath.Clean(archiveDir)
+		if !filepath.IsAbs(archiveDir) {
+			return fmt.Errorf("archive directory must be an absolute path")
+		}
+		if rel, err := filepath.Rel(downloadDir, archiveDir); err == nil && !strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("archive directory can't be inside the download directory")
+		}
+	}
+	c.Lock()
+	c.Retention = enabled
+	c.ArchiveDir = archiveDir
+	c.Unlock()
+	return c.Save()
+}
+
+func (c *Config) AddRetentionRule(rule RetentionRule) error {
+	c.Lock()
+	for _, r := range c.RetentionRules {
+		if r.ID == rule.ID {
+			c.Unlock()
+			return fmt.Errorf("rule already exists")
+		}
+	}
+	c.RetentionRules = append(c.RetentionRules, rule)
+	c.Unlock()
+	return c.Save()
+}
+
+func (c *Config) RemoveRetentionRule(id string) error {
+	c.Lock()
+	var rules []RetentionRule
+	for _, r := range c.RetentionRules {
+		if r.ID == id {
+			continue
+		}
+		rules = append(rules, r)
+	}
+	c.RetentionRules = rules
+	c.Unlock()
+	return c.Save()
+}
+
+func (c *Config) SetAutoConvert(convert, keep bool) error {
+	c.Lock()
+	c.AutoConvert = convert
+	c.KeepOriginals = keep
+	c.Unlock()
+	return c.Save()
+}
+
+func (c *Config) AddConvertRule(rule ConvertRule) error {
+	c.Lock()
+	for _, r := range c.ConvertRules {
+		if r.ID == rule.ID {
+			c.Unlock()
+			return fmt.Errorf("rule already exists")
+		}
+	}
+	c.ConvertRules = append(c.ConvertRules, rule)
+	c.Unlock()
+	return c.Save()
+}
+
+func (c *Config) RemoveConvertRule(id string) error {
+	c.Lock()
+	var rules []ConvertRule
+	for _, r := range c.ConvertRules {
+		if r.ID == id {
+			continue
+		}
+		rules = append(rules, r)
+	}
+	c.ConvertRules = rules
+	c.Unlock()
+	return c.Save()
+}
+
+// Profiles returns the built-in transcoding profiles followed by the added ones.
+func (c *Config) Profiles() []transcoder.Profile {
+	return append(append([]transcoder.Profile(nil), transcoder.BuiltinProfiles...), c.TranscodeProfiles...)
+}
+
+// FindProfile returns the transcoding profile with the ID, or the default profile if the ID is "".
+func (c *Config) FindProfile(id string) (transcoder.Profile, error) {
+	if id == "" {
+		return c.DefaultProfile(), nil
+	}
+	for _, p := range c.Profiles() {
+		if p.ID() == id {
+			return p, nil
+		}
+	}
+	return transcoder.Profile{}, fmt.Errorf("unknown transcoding profile %q", id)
+}
+
+// DefaultProfile returns the profile used when none is picked, falling back to the built-in default.
+func (c *Config) DefaultProfile() transcoder.Profile {
+	for _, p := range c.Profiles() {
+		if p.ID() == c.TranscodeProfile {
+			return p
+		}
+	}
+	return transcoder.BuiltinProfiles[0]
+}
+
+func (c *Config) SetTranscodeProfile(id string) error {
+	cfg := c.Get()
+	if _, err := cfg.FindProfile(id); err != nil {
+		return err
+	}
+	c.Lock()
+	c.TranscodeProfile = id
+	c.Unlock()
+	return c.Save()
+}
+
+func (c *Config) AddTranscodeProfile(p transcoder.Profile) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	cfg := c.Get()
+	if _, err := cfg.FindProfile(p.ID()); err == nil {
+		return fmt.Errorf("a profile named %q already exists", p.ID())
+	}
+	c.Lock()
+	c.TranscodeProfiles = append(c.TranscodeProfiles, p)
+	c.Unlock()
+	return c.Save()
+}
+
+// RemoveTranscodeProfile removes an added profile; if it was the default, the built-in default takes over.
+func (c *Config) RemoveTranscodeProfile(id string) error {
+	c.Lock()
+	var profiles []transcoder.Profile
+	for _, p := range c.TranscodeProfiles {
+		if p.ID() == id {
+			continue
+		}
+		profiles = append(profiles, p)
+	}
+	c.TranscodeProfiles = profiles
+	if c.TranscodeProfile == id {
+		c.TranscodeProfile = ""
+	}
+	c.Unlock()
+	return c.Save()
+}
+
+func (c *Config) AddHook(h Hook) error {
+	c.Lock()
+	for _, hook := range c.Hooks {
+		if hook.ID == h.ID {
+			c.Unlock()
+			return fmt.Errorf("hook already exists")
+		}
+	}
+	c.Hooks = append(c.Hooks, h)
+	c.Unlock()
+	return c.Save()
+}
+
+func (c *Config) RemoveHook(id string) error {
+	c.Lock()
+	var hooks []Hook
+	for _, hook := range c.Hooks {
+		if hook.ID == id {
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+	c.Hooks = hooks
+	c.Unlock()
+	return c.Save()
+}
+
 func (c *Config) Save() error {
 	c.RLock()
 	defer c.RUnlock()
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/viewscreen/viewscreen/internal/transcoder"
+)
+
+// ConvertRule overrides the auto-convert settings for downloads with matching names.
+type ConvertRule struct {
+	ID      string `json:"id"`
+	Pattern string `json:"pattern"`
+	Convert bool   `json:"convert"`
+	Keep    bool   `json:"keep"`
+}
+
+func NewConvertRule(pattern string, convert, keep bool) (ConvertRule, error) {
+	if pattern == "" {
+		return ConvertRule{}, fmt.Errorf("missing pattern")
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return ConvertRule{}, fmt.Errorf("invalid pattern: %s", err)
+	}
+	return ConvertRule{
+		ID:      fmt.Sprintf("%x", md5.Sum([]byte(pattern))),
+		Pattern: pattern,
+		Convert: convert,
+		Keep:    keep,
+	}, nil
+}
+
+// Match returns true if the download ID matches the pattern, ignoring case.
+func (r ConvertRule) Match(id string) bool {
+	re, err := regexp.Compile("(?i)" + r.Pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(id)
+}
+
+// ConvertPolicy returns whether a download is converted automatically,
+// and whether the original files are kept. The first matching rule wins.
+func ConvertPolicy(id string) (convert, keep bool) {
+	c := config.Get()
+	for _, rule := range c.ConvertRules {
+		if rule.Match(id) {
+			return rule.Convert, rule.Keep
+		}
+	}
+	return c.AutoConvert, c.KeepOriginals
+}
+
+// autoConvert queues the convertible videos of a finished download.
+// Originals stay in place while the torrent is seeding.
+func autoConvert(dir string, seeding bool) {
+	dl := Download{ID: filepath.Base(dir)}
+	convert, keep := ConvertPolicy(dl.ID)
+	if !convert {
+		return
+	}
+	cfg := config.Get()
+	profile := cfg.DefaultProfile()
+	for _, f := range dl.Files(false) {
+		if !f.Convertible() {
+			continue
+		}
+		if err := addTranscode(f.Path, profile, keep || seeding); err != nil {
+			logger.Errorf("auto-convert %q failed: %s", f.Path, err)
+			continue
+		}
+		logger.Infof("auto-convert queued %q", f.Path)
+	}
+}
+
+// releaseOriginals removes the originals of converted files once their torrent is done seeding.
+func releaseOriginals(dir string) {
+	dl := Download{ID: filepath.Base(dir)}
+	convert, keep := ConvertPolicy(dl.ID)
+	if !convert || keep {
+		return
+	}
+	cfg := config.Get()
+	profile := cfg.DefaultProfile()
+	for _, f := range dl.Files(false) {
+		if !f.Convertible() {
+			continue
+		}
+		// Jobs that haven't finished yet remove the original themselves.
+		if tcer.SetKeep(f.Path, false) {
+			continue
+		}
+		if _, err := os.Stat(transcoder.Output(f.Path, profile)); err != nil {
+			continue
+		}
+		if err := os.Remove(f.Path); err != nil {
+			logger.Errorf("removing original %q failed: %s", f.Path, err)
+			continue
+		}
+		logger.Infof("removed original %q after seeding", f.Path)
+	}
+}
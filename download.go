@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -57,8 +58,43 @@ func (dl Download) Share() error {
 	if err := os.MkdirAll(path, 0755); err != nil {
 		return err
 	}
-	_, err := os.Create(dl.Sharefile())
-	return err
+	if _, err := os.Create(dl.Sharefile()); err != nil {
+		return err
+	}
+
+	// Hash the files now, so friends don't wait for it.
+	go dl.HashFiles()
+	return nil
+}
+
+// Downloads whose files are being hashed.
+var (
+	hashingMu sync.Mutex
+	hashing   = make(map[string]bool)
+)
+
+// HashFiles caches the hashes of all files in the download.
+// It returns right away if the download is already being hashed.
+func (dl Download) HashFiles() {
+	hashingMu.Lock()
+	if hashing[dl.ID] {
+		hashingMu.Unlock()
+		return
+	}
+	hashing[dl.ID] = true
+	hashingMu.Unlock()
+
+	defer func() {
+		hashingMu.Lock()
+		delete(hashing, dl.ID)
+		hashingMu.Unlock()
+	}()
+
+	for _, f := range dl.Files(false) {
+		if _, err := f.Hash(); err != nil {
+			logger.Warnf("hashing %q failed: %s", f.Path, err)
+		}
+	}
 }
 
 func (dl Download) Unshare() error {
@@ -68,6 +104,41 @@ func (dl Download) Unshare() error {
 	return os.Remove(dl.Sharefile())
 }
 
+func (dl Download) Watchedfile() string {
+	return filepath.Join(downloadDir, ".watched", dl.ID)
+}
+
+func (dl Download) Watched() bool {
+	_, err := os.Stat(dl.Watchedfile())
+	return err == nil
+}
+
+// LastWatched returns when a file of the download was last viewed, or the zero time.
+func (dl Download) LastWatched() time.Time {
+	fi, err := os.Stat(dl.Watchedfile())
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// Watch records that the download was viewed now.
+func (dl Download) Watch() error {
+	now := time.Now()
+	if dl.Watched() {
+		return os.Chtimes(dl.Watchedfile(), now, now)
+	}
+	path := filepath.Dir(dl.Watchedfile())
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(dl.Watchedfile())
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
 func (dl Download) Path() string {
 	path := filepath.Join(downloadDir, dl.ID)
 	path = filepath.Clean(path)
@@ -98,6 +169,15 @@ func (dl Download) Size() int64 {
 	return size
 }
 
+// Duration returns the total length of the download's media files.
+func (dl Download) Duration() time.Duration {
+	var d time.Duration
+	for _, f := range dl.Files(false) {
+		d += f.Duration()
+	}
+	return d
+}
+
 func (dl Download) Files(thumbnails bool) []File {
 	var files []File
 	filepath.Walk(dl.Path(), func(path string, info os.FileInfo, err error) error {
@@ -105,6 +185,10 @@ func (dl Download) Files(thumbnails bool) []File {
 			return nil
 		}
 		if info.IsDir() {
+			// Skip hidden directories, like HLS packages.
+			if path != dl.Path() && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 		if !thumbnails {
@@ -1,9 +1,18 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/viewscreen/viewscreen/internal/probe"
+	"github.com/viewscreen/viewscreen/internal/transcoder"
 )
 
 type File struct {
@@ -16,6 +25,14 @@ func (f File) Transcoding() bool {
 	return ActiveTranscode(f.Path)
 }
 
+func (f File) TranscodeMode() string {
+	return TranscodeMode(f.Path)
+}
+
+func (f File) TranscodeProgress() transcoder.Progress {
+	return TranscodeProgress(f.Path)
+}
+
 func (f File) Clickable() bool {
 	switch f.Ext() {
 	case "jpg", "jpeg", "gif", "png", "txt", "pdf":
@@ -32,26 +49,131 @@ func (f File) Ext() string {
 	return strings.TrimPrefix(strings.ToLower(filepath.Ext(f.Info.Name())), ".")
 }
 
+// Viewable returns true if the browser can play the file, or its HLS package.
 func (f File) Viewable() bool {
-	if strings.Contains(f.Base(), "sample") {
+	return viewable(f.Base()) || f.HLS()
+}
+
+// HLS returns true if the file has been packaged as HLS.
+func (f File) HLS() bool {
+	_, err := os.Stat(filepath.Join(transcoder.HLSDir(f.Path), transcoder.HLSPlaylist))
+	return err == nil
+}
+
+// viewable returns true if the browser can play the file by name.
+func viewable(name string) bool {
+	if strings.Contains(name, "sample") {
 		return false
 	}
-	switch f.Ext() {
+	switch strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".") {
 	case "mp4", "m4v", "m4a", "m4b", "mp3":
 		return true
 	}
 	return false
 }
 
-func (f File) Convertible() bool {
+// Media returns true for audio and video files.
+func (f File) Media() bool {
 	switch f.Ext() {
-	case "avi", "flv", "mov", "mkv", "webm", "wma":
+	case "mp4", "m4v", "m4a", "m4b", "mp3", "avi", "flv", "mov", "mkv", "webm", "wma":
 		return true
 	}
 	return false
 }
 
+// Probe returns the media info of the file, or nil if it isn't media or can't be probed.
+func (f File) Probe() *probe.Info {
+	if !f.Media() {
+		return nil
+	}
+	info, err := probe.Cached(f.Path)
+	if err != nil {
+		logger.Warnf("probing %q failed: %s", f.Path, err)
+		return nil
+	}
+	return info
+}
+
+// Duration returns the length of the media, or 0 if it isn't known.
+func (f File) Duration() time.Duration {
+	if info := f.Probe(); info != nil {
+		return info.Duration()
+	}
+	return 0
+}
+
+// Convertible returns true if browsers can't play the file without converting it.
+// The codecs decide when the media info is known, otherwise the extension does.
+func (f File) Convertible() bool {
+	if !f.Media() {
+		return false
+	}
+	info := f.Probe()
+	if info == nil {
+		switch f.Ext() {
+		case "avi", "flv", "mov", "mkv", "webm", "wma":
+			return true
+		}
+		return false
+	}
+
+	switch f.Ext() {
+	case "mp4", "m4v", "m4a", "m4b", "mp3":
+	default:
+		return true
+	}
+	return !info.VideoStreamable() || !info.AudioStreamable()
+}
+
 func (f File) Thumbnail() bool {
 	_, err := os.Stat(f.Path + ".thumbnail.png")
 	return err == nil
 }
+
+// Hashfile returns the path to the cached hash of the file.
+func (f File) Hashfile() string {
+	return filepath.Join(filepath.Dir(f.Path), "."+f.Base()+".sha256")
+}
+
+// hashStamp identifies the version of the file a cached hash is for.
+func (f File) hashStamp() string {
+	return fmt.Sprintf("%d %d", f.Info.Size(), f.Info.ModTime().UnixNano())
+}
+
+// CachedHash returns the cached SHA-256 hash of the file, if it's still current.
+func (f File) CachedHash() (string, bool) {
+	// The cache holds the hash, size and modification time of the file.
+	b, err := ioutil.ReadFile(f.Hashfile())
+	if err != nil {
+		return "", false
+	}
+	fields := strings.SplitN(strings.TrimSpace(string(b)), " ", 2)
+	if len(fields) != 2 || fields[1] != f.hashStamp() {
+		return "", false
+	}
+	return fields[0], true
+}
+
+// Hash returns the SHA-256 hash of the file, cached until the file changes.
+func (f File) Hash() (string, error) {
+	if hash, ok := f.CachedHash(); ok {
+		return hash, nil
+	}
+
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	if err := Overwrite(f.Hashfile(), []byte(hash+" "+f.hashStamp()+"\n"), 0644); err != nil {
+		logger.Warnf("caching hash of %q failed: %s", f.Path, err)
+	}
+	return hash, nil
+}
@@ -22,6 +22,7 @@ type FriendDownload struct {
 type FriendFile struct {
 	ID   string
 	Size int64
+	Hash string
 }
 
 func (f *Friend) Downloads() []FriendDownload {
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/viewscreen/viewscreen/internal/downloader"
+	"github.com/viewscreen/viewscreen/internal/transcoder"
+)
+
+// EventTranscoded is sent when a transcode job finishes, with or without an error.
+const EventTranscoded = "transcode.finished"
+
+// HookEvents are the events a hook can run on.
+var HookEvents = []string{
+	downloader.EventAdded,
+	downloader.EventCompleted,
+	downloader.EventFailed,
+	downloader.EventSeeded,
+	EventTranscoded,
+}
+
+var (
+	// Failed webhooks are retried with an increasing delay.
+	hookAttempts = 5
+	hookBackoff  = 5 * time.Second
+
+	hookTimeout        = 30 * time.Second
+	hookCommandTimeout = 10 * time.Minute
+)
+
+// Hook is a webhook or a local command run on events.
+type Hook struct {
+	ID      string   `json:"id"`
+	Events  []string `json:"events"`
+	URL     string   `json:"url,omitempty"`
+	Command string   `json:"command,omitempty"`
+}
+
+// HookEvent is the JSON body of a webhook.
+type HookEvent struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	ID    string    `json:"id"`
+	Name  string    `json:"name"`
+	Path  string    `json:"path"`
+	URL   string    `json:"url,omitempty"`
+	Size  int64     `json:"size"`
+	Error string    `json:"error,omitempty"`
+	User  string    `json:"user,omitempty"`
+}
+
+func NewHook(events []string, rawurl, command string) (Hook, error) {
+	if (rawurl == "") == (command == "") {
+		return Hook{}, fmt.Errorf("a hook needs either a URL or a command")
+	}
+	if rawurl != "" {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return Hook{}, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return Hook{}, fmt.Errorf("webhook URL must be http or https")
+		}
+	}
+	for _, event := range events {
+		known := false
+		for _, e := range HookEvents {
+			if event == e {
+				known = true
+			}
+		}
+		if !known {
+			return Hook{}, fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return Hook{
+		ID:      fmt.Sprintf("%x", md5.Sum([]byte(rawurl+command))),
+		Events:  events,
+		URL:     rawurl,
+		Command: command,
+	}, nil
+}
+
+// Handles returns true if the hook runs on the event. Hooks without events run on all of them.
+func (h Hook) Handles(event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (h Hook) String() string {
+	if h.URL != "" {
+		return h.URL
+	}
+	return h.Command
+}
+
+// Run sends the event to the webhook, or runs the command.
+func (h Hook) Run(ev HookEvent) error {
+	if h.URL != "" {
+		return h.post(ev)
+	}
+	return h.exec(ev)
+}
+
+func (h Hook) post(ev HookEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	backoff := hookBackoff
+	for attempt := 1; ; attempt++ {
+		err = func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+			defer cancel()
+
+			req, err := http.NewRequest("POST", h.URL, bytes.NewReader(b))
+			if err != nil {
+				return err
+			}
+			req = req.WithContext(ctx)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("User-Agent", "viewscreen/"+version)
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			defer res.Body.Close()
+			if res.StatusCode < 200 || res.StatusCode >= 300 {
+				return fmt.Errorf("webhook returned %s", res.Status)
+			}
+			return nil
+		}()
+		if err == nil || attempt == hookAttempts {
+			return err
+		}
+		logger.Warnf("hook %q: %s event attempt %d failed: %s (retrying in %s)", h.URL, ev.Event, attempt, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (h Hook) exec(ev HookEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hookCommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", h.Command)
+	cmd.Env = append(os.Environ(),
+		"VIEWSCREEN_EVENT="+ev.Event,
+		"VIEWSCREEN_ID="+ev.ID,
+		"VIEWSCREEN_NAME="+ev.Name,
+		"VIEWSCREEN_PATH="+ev.Path,
+		"VIEWSCREEN_URL="+ev.URL,
+		fmt.Sprintf("VIEWSCREEN_SIZE=%d", ev.Size),
+		"VIEWSCREEN_ERROR="+ev.Error,
+		"VIEWSCREEN_USER="+ev.User,
+	)
+	output, err := cmd.CombinedOutput()
+	if out := strings.TrimSpace(string(output)); out != "" {
+		logger.Infof("hook %q: %s", h.Command, out)
+	}
+	return err
+}
+
+// RunHooks runs the hooks for the event in the background.
+func RunHooks(ev HookEvent) {
+	ev.Time = time.Now()
+	for _, h := range config.Get().Hooks {
+		if !h.Handles(ev.Event) {
+			continue
+		}
+		go func(h Hook) {
+			if err := h.Run(ev); err != nil {
+				logger.Errorf("hook %q: %s event failed: %s", h, ev.Event, err)
+				return
+			}
+			logger.Infof("hook %q: %s event for %q done", h, ev.Event, ev.Name)
+		}(h)
+	}
+}
+
+// transferEvent handles a downloader event and runs its hooks.
+func transferEvent(e downloader.Event) {
+	// Converted originals are no longer needed once seeding is done.
+	if e.Type == downloader.EventSeeded {
+		releaseOriginals(e.Dir)
+	}
+
+	ev := HookEvent{
+		Event: e.Type,
+		ID:    e.ID,
+		Name:  e.Name,
+		Path:  e.Dir,
+		URL:   e.URL,
+		Size:  e.Size,
+		User:  e.User,
+	}
+	if e.Error != nil {
+		ev.Error = e.Error.Error()
+	}
+	RunHooks(ev)
+}
+
+// transcodeEvent records a finished transcode job in the history and runs the hooks for it.
+func transcodeEvent(res transcoder.Result) {
+	dler.Release(transcodeReservation(res.Src, res.Profile))
+	recordTranscode(res)
+
+	ev := HookEvent{
+		Event: EventTranscoded,
+		Name:  filepath.Base(res.Src),
+		Path:  res.Src,
+	}
+	if rel, err := filepath.Rel(downloadDir, res.Src); err == nil {
+		ev.ID = strings.Split(rel, string(filepath.Separator))[0]
+	}
+	if res.Err != nil {
+		ev.Error = res.Err.Error()
+	} else {
+		ev.Path = res.Dst
+		if fi, err := os.Stat(res.Dst); err == nil {
+			ev.Size = fi.Size()
+		}
+		// Hash the new file now, so friends don't wait for it.
+		if dl := (Download{ID: ev.ID}); ev.ID != "" && dl.Shared() {
+			go dl.HashFiles()
+		}
+	}
+	RunHooks(ev)
+}
@@ -0,0 +1,77 @@
+package downloader
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// The smallest burst allowed, so slow limits still let whole torrent chunks through.
+const minimumBurst = 64 * 1024
+
+// newLimiter returns a rate limiter for a speed in megabits per second. Zero is unlimited.
+func newLimiter(mbps int64) *rate.Limiter {
+	limiter := rate.NewLimiter(rate.Inf, minimumBurst)
+	setLimit(limiter, mbps)
+	return limiter
+}
+
+func setLimit(limiter *rate.Limiter, mbps int64) {
+	if mbps <= 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+	// rate in bytes per second (from megabits per second)
+	bps := int((mbps * (1024 * 1024)) / 8)
+	burst := bps
+	if burst < minimumBurst {
+		burst = minimumBurst
+	}
+	limiter.SetBurst(burst)
+	limiter.SetLimit(rate.Limit(bps))
+}
+
+func getLimit(limiter *rate.Limiter) int64 {
+	if limiter.Limit() == rate.Inf {
+		return 0
+	}
+	return int64(limiter.Limit()) * 8 / (1024 * 1024)
+}
+
+// SetSpeeds changes the upload and download speed limits, in megabits per second.
+// Zero means unlimited. HTTP and friend downloads share the download limit.
+func (l *Downloader) SetSpeeds(up, down int64) {
+	setLimit(l.uploadLimiter, up)
+	setLimit(l.downloadLimiter, down)
+}
+
+// Speeds returns the upload and download speed limits in megabits per second.
+func (l *Downloader) Speeds() (int64, int64) {
+	return getLimit(l.uploadLimiter), getLimit(l.downloadLimiter)
+}
+
+// throttle limits reads from r to the download speed.
+func (l *Downloader) throttle(ctx context.Context, r io.Reader) io.Reader {
+	return &limitedReader{ctx: ctx, r: r, limiter: l.downloadLimiter}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	// Reads can't be bigger than the burst, or waiting for them fails.
+	if r.limiter.Limit() != rate.Inf && len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.limiter.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
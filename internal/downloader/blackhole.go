@@ -0,0 +1,145 @@
+package downloader
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The watch dir is a "blackhole" for .torrent files and text files of magnet links.
+// Each file is moved to one of these subdirectories once it has been queued.
+const (
+	watchProcessed = "processed"
+	watchFailed    = "failed"
+)
+
+// watch polls the watch dir for new files.
+func (l *Downloader) watch(dir string) {
+	for {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			l.Config.Logger.Errorf("watch dir: %s", err)
+		}
+		for _, fi := range files {
+			if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+				continue
+			}
+			// Leave files alone that may still be written.
+			if time.Since(fi.ModTime()) < 3*time.Second {
+				continue
+			}
+
+			filename := filepath.Join(dir, fi.Name())
+			var err error
+			switch strings.ToLower(filepath.Ext(fi.Name())) {
+			case ".torrent":
+				err = l.watchTorrent(filename)
+			case ".magnet", ".txt":
+				err = l.watchLinks(filename)
+			default:
+				continue
+			}
+			sub := watchProcessed
+			if err != nil {
+				l.Config.Logger.Errorf("watch dir: %q failed: %s", fi.Name(), err)
+				sub = watchFailed
+			}
+			if _, err := moveTo(filename, filepath.Join(dir, sub)); err != nil {
+				l.Config.Logger.Errorf("watch dir: %s", err)
+			}
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// watchTorrent queues a .torrent file from the watch dir.
+func (l *Downloader) watchTorrent(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := l.AddTorrent(f, ""); err != nil {
+		return err
+	}
+	l.Config.Logger.Infof("watch dir: queued %q", filepath.Base(filename))
+	return nil
+}
+
+// watchLinks queues every magnet or HTTP link in a text file from the watch dir.
+// If only some of the links fail, they're written to a file of their own in the
+// failed directory, so putting that back doesn't queue the others again.
+func (l *Downloader) watchLinks(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n := 0
+	var failed []string
+	var errs []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		link := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(link, "magnet:") && !strings.HasPrefix(link, "http://") && !strings.HasPrefix(link, "https://") {
+			continue
+		}
+		if _, err := l.Add(link, ""); err != nil {
+			// Lines that aren't links are skipped, so the error can go along as a comment.
+			failed = append(failed, "# "+err.Error(), link)
+			errs = append(errs, err.Error())
+			continue
+		}
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if n == 0 && len(errs) == 0 {
+		return fmt.Errorf("no links found")
+	}
+	if n == 0 {
+		return fmt.Errorf("%d links failed: %s", len(errs), strings.Join(errs, "; "))
+	}
+	l.Config.Logger.Infof("watch dir: queued %d links from %q", n, filepath.Base(filename))
+
+	if len(errs) > 0 {
+		l.Config.Logger.Errorf("watch dir: %d links from %q failed: %s", len(errs), filepath.Base(filename), strings.Join(errs, "; "))
+		// The rest are queued, so the file itself still counts as processed.
+		dir := filepath.Join(filepath.Dir(filename), watchFailed)
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			l.Config.Logger.Errorf("watch dir: %s", err)
+			return nil
+		}
+		dst := freeName(dir, filepath.Base(filename))
+		if err := ioutil.WriteFile(dst, []byte(strings.Join(failed, "\n")+"\n"), 0640); err != nil {
+			l.Config.Logger.Errorf("watch dir: %s", err)
+		}
+	}
+	return nil
+}
+
+// moveTo moves the file into dir without overwriting, and returns the new path.
+func moveTo(filename, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+	dst := freeName(dir, filepath.Base(filename))
+	return dst, os.Rename(filename, dst)
+}
+
+// freeName returns a path in dir for the file name that isn't taken yet.
+func freeName(dir, name string) string {
+	dst := filepath.Join(dir, name)
+	if _, err := os.Stat(dst); err == nil {
+		dst = filepath.Join(dir, fmt.Sprintf("%d-%s", time.Now().Unix(), name))
+	}
+	return dst
+}
@@ -1,8 +1,11 @@
 package downloader
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,10 +18,13 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/viewscreen/viewscreen/internal/probe"
+
 	log "github.com/Sirupsen/logrus"
 
 	"github.com/anacrolix/torrent"
@@ -48,6 +54,13 @@ type Downloader struct {
 	torrent   *torrent.Client
 	transfers []*Transfer
 
+	uploadLimiter   *rate.Limiter
+	downloadLimiter *rate.Limiter
+
+	// Storage set aside outside of transfers, and whether downloads are held for space.
+	reserved map[string]int64
+	holding  bool
+
 	Config *Config
 }
 
@@ -59,11 +72,28 @@ type Config struct {
 
 	TorrentAddr string
 
+	// WatchDir is checked for .torrent and magnet link files to add (optional).
+	WatchDir string
+
+	// Events is called with transfer events (optional).
+	Events func(Event)
+
+	// Convert is called by PostProcess with the finished download dir, to queue
+	// conversions. Seeding is true when the torrent still needs the files (optional).
+	Convert func(dir string, seeding bool)
+
 	// mu protects the below, which can be accessed safely using getters/setters.
 	mu            sync.RWMutex
 	TransferSlots int
 	DownloadDir   string
 	TorrentRatio  float64
+	SeedMinTime   time.Duration
+	SeedMaxTime   time.Duration
+	SeedIdleTime  time.Duration
+	SelectFiles   bool
+
+	// MinFree is the free space in bytes below which downloads are held (optional).
+	MinFree int64
 }
 
 func (c *Config) RLock(loc string) {
@@ -124,6 +154,53 @@ func (c *Config) SetTorrentRatio(ratio float64) {
 	c.TorrentRatio = ratio
 }
 
+// SeedRules are the default seeding rules for new transfers.
+func (c *Config) GetSeedRules() SeedRules {
+	c.RLock("GetSeedRules")
+	defer c.RUnlock("GetSeedRules")
+	return SeedRules{
+		Ratio:    c.TorrentRatio,
+		MinTime:  c.SeedMinTime,
+		MaxTime:  c.SeedMaxTime,
+		IdleTime: c.SeedIdleTime,
+	}
+}
+
+func (c *Config) SetSeedRules(rules SeedRules) {
+	c.Lock("SetSeedRules")
+	defer c.Unlock("SetSeedRules")
+	c.TorrentRatio = rules.Ratio
+	c.SeedMinTime = rules.MinTime
+	c.SeedMaxTime = rules.MaxTime
+	c.SeedIdleTime = rules.IdleTime
+}
+
+// MinFree
+func (c *Config) GetMinFree() int64 {
+	c.RLock("GetMinFree")
+	defer c.RUnlock("GetMinFree")
+	return c.MinFree
+}
+
+func (c *Config) SetMinFree(n int64) {
+	c.Lock("SetMinFree")
+	defer c.Unlock("SetMinFree")
+	c.MinFree = n
+}
+
+// SelectFiles
+func (c *Config) GetSelectFiles() bool {
+	c.RLock("GetSelectFiles")
+	defer c.RUnlock("GetSelectFiles")
+	return c.SelectFiles
+}
+
+func (c *Config) SetSelectFiles(v bool) {
+	c.Lock("SetSelectFiles")
+	defer c.Unlock("SetSelectFiles")
+	c.SelectFiles = v
+}
+
 func NewDownloader(cfg *Config) (*Downloader, error) {
 	// Defaults
 	if cfg.UploadSpeed == 0 {
@@ -150,6 +227,18 @@ func NewDownloader(cfg *Config) (*Downloader, error) {
 		}
 	}
 
+	l := &Downloader{
+		Config:          cfg,
+		uploadLimiter:   newLimiter(cfg.UploadSpeed),
+		downloadLimiter: newLimiter(cfg.DownloadSpeed),
+		reserved:        make(map[string]int64),
+	}
+
+	// Reload transfers from the last run.
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
 	// clean up temp files
 	tmpfiles, err := func() ([]string, error) {
 		files, err := ioutil.ReadDir(cfg.DownloadDir)
@@ -162,7 +251,12 @@ func NewDownloader(cfg *Config) (*Downloader, error) {
 			if ext != "uploading" && ext != "downloading" {
 				continue
 			}
-			tmpfiles = append(tmpfiles, filepath.Join(cfg.DownloadDir, file.Name()))
+			filename := filepath.Join(cfg.DownloadDir, file.Name())
+			// Keep the markers of transfers that are resuming.
+			if l.resuming(strings.TrimSuffix(filename, "."+ext)) {
+				continue
+			}
+			tmpfiles = append(tmpfiles, filename)
 		}
 		return tmpfiles, nil
 	}()
@@ -177,15 +271,11 @@ func NewDownloader(cfg *Config) (*Downloader, error) {
 		}
 	}
 
-	// rate in bytes per second (from megabits per second)
-	uprate := int((cfg.UploadSpeed * (1024 * 1024)) / 8)
-	downrate := int((cfg.DownloadSpeed * (1024 * 1024)) / 8)
-
 	client, err := torrent.NewClient(&torrent.Config{
 		DataDir:             cfg.DownloadDir,
 		ListenAddr:          cfg.TorrentAddr,
-		UploadRateLimiter:   rate.NewLimiter(rate.Limit(uprate), uprate),
-		DownloadRateLimiter: rate.NewLimiter(rate.Limit(downrate), downrate),
+		UploadRateLimiter:   l.uploadLimiter,
+		DownloadRateLimiter: l.downloadLimiter,
 		Seed:                true,
 		DefaultStorage: storage.NewFileWithCustomPathMaker(
 			cfg.DownloadDir,
@@ -208,17 +298,23 @@ func NewDownloader(cfg *Config) (*Downloader, error) {
 		return nil, err
 	}
 
-	l := &Downloader{
-		torrent: client,
-		Config:  *&cfg,
-	}
+	l.torrent = client
 	go l.manager()
+
+	// Watch dir
+	if cfg.WatchDir != "" {
+		if err := os.MkdirAll(cfg.WatchDir, 0750); err != nil {
+			return nil, err
+		}
+		go l.watch(cfg.WatchDir)
+	}
 	return l, nil
 }
 
 type Transfer struct {
 	ID        string
 	URL       *url.URL
+	User      string
 	Created   time.Time
 	Started   time.Time
 	Completed time.Time
@@ -226,14 +322,28 @@ type Transfer struct {
 
 	DownloadDir string
 	Uploading   bool
-	SeedRatio   float64
+	Seed        SeedRules
+	Seeding     time.Time
 
 	Torrent *torrent.Torrent
 	Error   error
 
-	// Friend downloads
+	// Torrent file selection, by file path.
+	FilePriorities map[string]int
+	Selected       bool
+	Selecting      bool
+
+	// Queue controls
+	Paused   bool
+	Priority int
+
+	// Held while storage is low.
+	Held bool
+
+	// Friend and HTTP downloads
 	DownloadID   string
 	DownloadSize int64
+	received     *int64
 }
 
 //
@@ -263,6 +373,7 @@ func (l *Downloader) Unlock(loc string) {
 func (l *Downloader) manager() {
 	for {
 		l.Lock("manager")
+		changed := false
 		// count active transfers
 		active := 0
 		for _, t := range l.transfers {
@@ -276,33 +387,45 @@ func (l *Downloader) manager() {
 			active++
 		}
 
+		// clean up if completed
 		for _, t := range l.transfers {
-			// leave active transfers alone
-			if t.IsActive() {
-				continue
-			}
-			// clean up if completed
 			if t.IsCompleted() {
+				l.removeTorrentFile(t)
 				l.remove(t.ID)
-				continue
+				changed = true
 			}
-			// start
-			if active < l.Config.GetTransferSlots() {
-				active++
-				t.Started = time.Now()
-				l.Config.Logger.Debugf("downloader starting transfer %s %s", t.ID, t.URL)
-				go l.transfer(t)
-				continue
+		}
+
+		// hold or continue downloads depending on free space
+		if l.hold() {
+			changed = true
+		}
+
+		// start queued transfers in order
+		for _, t := range l.queue() {
+			if active >= l.Config.GetTransferSlots() {
+				break
 			}
+			active++
+			t.Started = time.Now()
+			changed = true
+			l.Config.Logger.Debugf("downloader starting transfer %s %s", t.ID, t.URL)
+			go l.transfer(t)
+		}
+		if changed {
+			l.save()
 		}
 		l.Unlock("manager")
 		time.Sleep(1 * time.Second)
 	}
 }
 
-func (l *Downloader) availableStorage(size int64) bool {
-	space := l.Config.Space()
-	space -= int64(float64(space) * 0.05) // reserve 5%
+// availableStorage returns true if the transfer fits in the storage
+// that isn't committed to other transfers.
+func (l *Downloader) availableStorage(t *Transfer, size int64) bool {
+	l.RLock("availableStorage")
+	space := l.available(t)
+	l.RUnlock("availableStorage")
 
 	if size >= space {
 		l.Config.Logger.Debugf("insufficient storage: download size %s greater than available space %s", humanize.Bytes(uint64(size)), humanize.Bytes(uint64(space)))
@@ -318,6 +441,8 @@ func (l *Downloader) transfer(t *Transfer) {
 	t.Cancel = &cancel
 	friend := t.URL.Query().Get("friend")
 	path := t.URL.Path
+	scheme := t.URL.Scheme
+	rawurl := t.URL.String()
 	l.Unlock("transfer")
 
 	var err error
@@ -326,8 +451,23 @@ func (l *Downloader) transfer(t *Transfer) {
 		// 1. Friend Download - v1 API friend download URL
 		// e.g. https://example.com/viewscreen/v1/downloads/files/<download>?friend=<host>
 		err = l.transferFriend(ctx, t)
+	} else if scheme == "http" || scheme == "https" {
+		// 2. HTTP - either a .torrent file or a file to download directly
+		var info *httpInfo
+		err = retry(ctx, l.Config.Logger, func() error {
+			var err error
+			info, err = probeHTTP(ctx, rawurl)
+			return err
+		})
+		if err == nil {
+			if info.Torrent {
+				err = l.transferTorrent(ctx, t)
+			} else {
+				err = l.transferHTTP(ctx, t, info)
+			}
+		}
 	} else {
-		// 2. Torrent
+		// 3. Torrent
 		err = l.transferTorrent(ctx, t)
 	}
 	if err != nil {
@@ -336,9 +476,27 @@ func (l *Downloader) transfer(t *Transfer) {
 
 	// Clean up
 	l.Lock("cleanup")
+	l.finish(ctx, t, err)
+	l.Unlock("cleanup")
+}
+
+// finish records how a transfer's goroutine ended.
+// A canceled transfer was paused, held or removed, and goes back in the queue to be
+// resumed later; that holds even if it was resumed again before its goroutine stopped.
+// The caller must hold the lock.
+func (l *Downloader) finish(ctx context.Context, t *Transfer, err error) {
+	if ctx.Err() != nil {
+		t.Started = time.Time{}
+		t.Cancel = nil
+		l.save()
+		return
+	}
 	t.Error = err
 	t.Completed = time.Now()
-	l.Unlock("cleanup")
+	if err != nil {
+		l.notify(EventFailed, t, err)
+	}
+	l.save()
 }
 
 func ffthumb(videofile, thumbfile string) error {
@@ -400,10 +558,20 @@ func (l *Downloader) PostProcess(ctx context.Context, t *Transfer) error {
 	for _, fi := range files {
 		ext := strings.TrimPrefix(filepath.Ext(fi.Name()), ".")
 		switch ext {
+		case "mp3", "m4a", "m4b", "wma":
+			// Cache the media info for the library.
+			if _, err := probe.Cached(filepath.Join(t.DownloadDir, fi.Name())); err != nil {
+				log.Warn(err)
+			}
 		case "mp4", "m4v", "avi", "flv", "mov", "mkv", "webm":
 			videofile := filepath.Join(t.DownloadDir, fi.Name())
 			thumbfile := filepath.Join(t.DownloadDir, fi.Name()+".thumbnail.png")
 
+			// Cache the media info for the library.
+			if _, err := probe.Cached(videofile); err != nil {
+				log.Warn(err)
+			}
+
 			if err := ffthumb(videofile, thumbfile); err != nil {
 				log.Warn(err)
 				continue
@@ -424,6 +592,27 @@ func (l *Downloader) PostProcess(ctx context.Context, t *Transfer) error {
 			return err
 		}
 	}
+
+	if l.Config.Convert != nil {
+		l.RLock("convert")
+		seeding := t.Torrent != nil && t.Seed.Seeds()
+		l.RUnlock("convert")
+		l.Config.Convert(t.DownloadDir, seeding)
+	}
+	return nil
+}
+
+// complete post-processes a finished download and announces it.
+func (l *Downloader) complete(ctx context.Context, t *Transfer) error {
+	if err := l.PostProcess(ctx, t); err != nil {
+		return err
+	}
+	if err := t.UnmarkDownloading(); err != nil {
+		return err
+	}
+	l.RLock("complete")
+	l.notify(EventCompleted, t, nil)
+	l.RUnlock("complete")
 	return nil
 }
 
@@ -436,22 +625,25 @@ func (l *Downloader) transferFriend(ctx context.Context, t *Transfer) error {
 	l.RUnlock("friend url")
 
 	// Download friend's file list.
-	res, err := GET(nil, rawurl)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	b, err := ioutil.ReadAll(io.LimitReader(res.Body, httpReadLimit))
-	if err != nil {
-		return err
-	}
-
 	var files []struct {
 		ID   string
 		Size int64
+		Hash string
 	}
-	if err := json.Unmarshal(b, &files); err != nil {
+	err := retry(ctx, l.Config.Logger, func() error {
+		res, err := GET(ctx, rawurl)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		b, err := ioutil.ReadAll(io.LimitReader(res.Body, httpReadLimit))
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, &files)
+	})
+	if err != nil {
 		return err
 	}
 
@@ -470,18 +662,23 @@ func (l *Downloader) transferFriend(ctx context.Context, t *Transfer) error {
 		downloadSize += f.Size
 	}
 
-	// Ensure we have enough storage.
-	if !l.availableStorage(downloadSize) {
+	dldir := filepath.Join(l.Config.GetDownloadDir(), downloadID)
+
+	// Ensure we have enough storage, not counting files copied before a pause.
+	remaining := downloadSize
+	if n, err := du(dldir); err == nil {
+		remaining -= n
+	}
+	if !l.availableStorage(t, remaining) {
 		return ErrInsufficientStorage
 	}
 
-	dldir := filepath.Join(l.Config.GetDownloadDir(), downloadID)
-
 	// Store ID for later.
 	l.Lock("transfer friend id")
 	t.DownloadID = downloadID
 	t.DownloadSize = downloadSize
 	t.DownloadDir = dldir
+	l.save()
 	l.Unlock("transfer friend id")
 
 	// Mark the transfer as downloading.
@@ -505,27 +702,65 @@ func (l *Downloader) transferFriend(ctx context.Context, t *Transfer) error {
 
 		l.Config.Logger.Debugf("Downloading friend's file %s %s", file.ID, endpoint)
 
-		res, err := GET(ctx, endpoint)
+		hash := file.Hash
+		err := retry(ctx, l.Config.Logger, func() error {
+			if err := l.resumeCopy(ctx, endpoint, filename, file.Size); err != nil {
+				return err
+			}
+			// Older friends don't publish hashes, and files still being hashed have none yet.
+			if hash == "" {
+				return nil
+			}
+			if err := verifyFile(filename, hash); err != nil {
+				os.Remove(filename)
+				return err
+			}
+			return nil
+		})
 		if err != nil {
-			return fmt.Errorf("friend stream request %q failed: %s", endpoint, err)
+			return err
 		}
-		defer res.Body.Close()
+	}
+	return l.complete(ctx, t)
+}
 
-		f, err := os.Create(filename)
-		if err != nil {
-			return fmt.Errorf("create %q failed: %s", filename, err)
-		}
-		if _, err = io.Copy(f, res.Body); err != nil {
-			return fmt.Errorf("copy failed for %q: %s", filename, err)
+// resumeCopy downloads a file, continuing from where a paused or dropped copy stopped.
+// A negative size means the size is unknown.
+func (l *Downloader) resumeCopy(ctx context.Context, endpoint, filename string, size int64) error {
+	var offset int64
+	if fi, err := os.Stat(filename); err == nil {
+		offset = fi.Size()
+	}
+	if size >= 0 {
+		if offset == size {
+			return nil
 		}
-		if err := f.Close(); err != nil {
-			return err
+		if offset > size {
+			offset = 0
 		}
 	}
-	if err := l.PostProcess(ctx, t); err != nil {
-		return err
+
+	res, err := getRange(ctx, endpoint, offset)
+	if err != nil {
+		return fmt.Errorf("stream request %q failed: %s", endpoint, err)
 	}
-	return t.UnmarkDownloading()
+	defer res.Body.Close()
+
+	// Start over unless the friend sent the rest of the file.
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 && res.StatusCode == http.StatusPartialContent {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+
+	f, err := os.OpenFile(filename, flags, 0640)
+	if err != nil {
+		return fmt.Errorf("create %q failed: %s", filename, err)
+	}
+	if _, err = io.Copy(f, l.throttle(ctx, res.Body)); err != nil {
+		f.Close()
+		return fmt.Errorf("copy failed for %q: %s", filename, err)
+	}
+	return f.Close()
 }
 
 func (l *Downloader) transferTorrent(ctx context.Context, t *Transfer) error {
@@ -557,21 +792,39 @@ func (l *Downloader) transferTorrent(ctx context.Context, t *Transfer) error {
 		tor, err := l.torrent.AddTorrent(metaInfo)
 		t.Torrent = tor
 		l.Unlock("torrent http add")
-		return err
+		if err != nil {
+			return err
+		}
+	} else if scheme == "file" {
+		l.RLock("torrent file path")
+		filename := t.URL.Path
+		uploaded := l.uploaded(t)
+		l.RUnlock("torrent file path")
+		if !uploaded {
+			return fmt.Errorf("torrent file %q is not an upload", filename)
+		}
+
+		metaInfo, err := metainfo.LoadFromFile(filename)
+		if err != nil {
+			return err
+		}
+
+		l.Lock("torrent file add")
+		tor, err := l.torrent.AddTorrent(metaInfo)
+		t.Torrent = tor
+		l.Unlock("torrent file add")
+		if err != nil {
+			return err
+		}
 	} else {
 		return fmt.Errorf("invalid or unrecognized torrent")
 	}
 
 	// Wait for info.
-	<-t.Torrent.GotInfo()
-
-	// Check if we have sufficient storage for the download.
-	var size int64
-	for _, file := range t.Torrent.Files() {
-		size += file.Length()
-	}
-	if !l.availableStorage(size) {
-		return ErrInsufficientStorage
+	select {
+	case <-t.Torrent.GotInfo():
+	case <-ctx.Done():
+		return l.stopTorrent(t)
 	}
 
 	info := t.Torrent.Info()
@@ -585,66 +838,100 @@ func (l *Downloader) transferTorrent(ctx context.Context, t *Transfer) error {
 
 	l.Lock("setting DownloadDir")
 	t.DownloadDir = dldir
+	uploading := t.Uploading
+	if !l.Config.GetSelectFiles() || len(t.Torrent.Files()) < 2 {
+		t.Selected = true
+	}
+	l.save()
 	l.Unlock("setting DownloadDir")
 
-	// Mark the transfer as downloading.
-	if err := t.MarkDownloading(); err != nil {
-		return err
+	// Let the user choose which files to download.
+	if !l.waitForSelection(t, ctx.Done()) {
+		return l.stopTorrent(t)
+	}
+
+	// Check if we have sufficient storage for the selected files,
+	// not counting data already on disk from a previous run.
+	l.RLock("selected size")
+	size := t.selectedSize()
+	l.RUnlock("selected size")
+	if n, err := du(dldir); err == nil {
+		size -= n
+	}
+	if !l.availableStorage(t, size) {
+		return ErrInsufficientStorage
+	}
+
+	// Mark the transfer as downloading, or uploading if it was seeding before a restart.
+	if uploading {
+		if err := t.MarkUploading(); err != nil {
+			return err
+		}
+	} else {
+		if err := t.MarkDownloading(); err != nil {
+			return err
+		}
 	}
-	// Start downloading all files in the torrent.
-	t.Torrent.DownloadAll()
+	// Start downloading the selected files in the torrent.
+	l.RLock("apply priorities")
+	t.applyPriorities()
+	l.RUnlock("apply priorities")
+
+	// Seeding started before a restart continues from where it was.
+	l.Lock("seeding since")
+	if uploading && t.Seeding.IsZero() {
+		t.Seeding = time.Now()
+	}
+	l.Unlock("seeding since")
+	lastPeer := time.Now()
 
 	ticker := time.NewTicker(3 * time.Second)
 	for {
 		select {
 		case <-ctx.Done():
-			l.Config.Logger.Infof("transfer %q canceled", t.Torrent.Info().Name)
-			if err := t.UnmarkDownloading(); err != nil {
-				return err
-			}
-			return t.UnmarkUploading()
+			return l.stopTorrent(t)
 		case <-ticker.C:
 			l.RLock("get Uploading")
 			uploading := t.Uploading
-			target := t.SeedRatio
+			rules := t.Seed
+			since := t.Seeding
 			l.RUnlock("get Uploading")
 
 			if uploading {
-				// Unless it's unlimited, cancel when the target ratio is reached.
-				if target > 0 {
-					bw := t.Torrent.Stats().DataBytesWritten
-					br := t.Torrent.Info().TotalLength()
-
-					var ratio float64
-					if bw > 0 && br > 0 {
-						ratio = float64(bw) / float64(br)
-					}
-
-					l.Config.Logger.Debugf("transfer is uploading written: %d read: %d ratio: %v >= target %v", bw, br, ratio, target)
-
-					if ratio >= target {
-						t.Torrent.Drop()
-						return t.UnmarkUploading()
-					}
+				if t.Torrent.Stats().ActivePeers > 0 {
+					lastPeer = time.Now()
+				}
+				ratio := t.Ratio()
+				l.Config.Logger.Debugf("transfer is uploading ratio: %.2f target: %v seeded: %s", ratio, rules.Ratio, time.Since(since))
+
+				if done, reason := rules.done(ratio, time.Since(since), time.Since(lastPeer)); done {
+					t.Torrent.Drop()
+					l.RLock("seeded")
+					l.Config.Logger.Infof("transfer %q stopped seeding: %s", t.String(), reason)
+					l.notify(EventSeeded, t, nil)
+					l.RUnlock("seeded")
+					return t.UnmarkUploading()
 				}
 			} else {
-				remaining := t.Torrent.BytesMissing()
+				l.RLock("get remaining")
+				remaining := t.selectedSize() - t.selectedCompleted()
+				l.RUnlock("get remaining")
 				l.Config.Logger.Debugf("transfer is downloading %s remaining", humanize.Bytes(uint64(remaining)))
 
 				if remaining == 0 {
-					if err := l.PostProcess(ctx, t); err != nil {
+					if err := l.complete(ctx, t); err != nil {
 						return err
 					}
-					if err := t.UnmarkDownloading(); err != nil {
-						return err
-					}
-					if target == 0 {
+					if !rules.Seeds() {
 						t.Torrent.Drop()
 						return nil
 					}
 
 					l.Lock("setting Uploading")
 					t.Uploading = true
+					t.Seeding = time.Now()
+					lastPeer = t.Seeding
+					l.save()
 					l.Unlock("setting Uploading")
 					if err := t.MarkUploading(); err != nil {
 						return err
@@ -656,6 +943,29 @@ func (l *Downloader) transferTorrent(ctx context.Context, t *Transfer) error {
 	}
 }
 
+// stopTorrent drops the torrent of a canceled, paused or held transfer.
+// Paused and held transfers keep their markers and data so they can be resumed.
+func (l *Downloader) stopTorrent(t *Transfer) error {
+	// Paused and held transfers, even if resumed since, are still in the list.
+	l.RLock("stop torrent")
+	_, err := l.findByID(t.ID)
+	paused := err == nil
+	name := t.String()
+	l.RUnlock("stop torrent")
+
+	t.Torrent.Drop()
+	if paused {
+		l.Config.Logger.Infof("transfer %q paused", name)
+		return nil
+	}
+
+	l.Config.Logger.Infof("transfer %q canceled", name)
+	if err := t.UnmarkDownloading(); err != nil {
+		return err
+	}
+	return t.UnmarkUploading()
+}
+
 func (l *Downloader) Busy() bool {
 	l.RLock("Busy")
 	defer l.RUnlock("Busy")
@@ -677,17 +987,20 @@ func (l *Downloader) ListActive() []Transfer {
 	return transfers
 }
 
+// ListPending returns queued transfers in the order they will start, then paused transfers.
 func (l *Downloader) ListPending() []Transfer {
 	l.RLock("ListPending")
 	defer l.RUnlock("ListPending")
 
 	var transfers []Transfer
+	for _, t := range l.queue() {
+		transfers = append(transfers, *t)
+	}
 	for _, t := range l.transfers {
-		if t.IsActive() {
+		if t.IsActive() || !(t.Paused || t.Held) {
 			continue
 		}
 		transfers = append(transfers, *t)
-
 	}
 	return transfers
 }
@@ -776,11 +1089,29 @@ func (l *Downloader) findByID(id string) (*Transfer, error) {
 	return nil, ErrTransferNotFound
 }
 
-func (l *Downloader) Add(rawurl string) (Transfer, error) {
+// ParseURL parses a URL that can be added as a transfer: a magnet, http or https link.
+// Uploaded .torrent files are added with AddTorrent, never by URL.
+func ParseURL(rawurl string) (*url.URL, error) {
+	if rawurl == "" {
+		return nil, fmt.Errorf("missing URL")
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "magnet", "http", "https":
+		return u, nil
+	}
+	return nil, fmt.Errorf("unsupported URL %q: use a magnet, http or https link", rawurl)
+}
+
+// Add queues the URL, recording the user who added it (optional).
+func (l *Downloader) Add(rawurl, user string) (Transfer, error) {
 	l.Lock("Add")
 	defer l.Unlock("Add")
 
-	u, err := url.Parse(rawurl)
+	u, err := ParseURL(rawurl)
 	if err != nil {
 		return Transfer{}, err
 	}
@@ -792,15 +1123,83 @@ func (l *Downloader) Add(rawurl string) (Transfer, error) {
 	}
 
 	t := &Transfer{
-		ID:        fmt.Sprintf("%x", md5.Sum([]byte(u.String()))),
-		URL:       u,
-		Created:   time.Now(),
-		SeedRatio: l.Config.GetTorrentRatio(),
+		ID:      fmt.Sprintf("%x", md5.Sum([]byte(u.String()))),
+		URL:     u,
+		User:    user,
+		Created: time.Now(),
+		Seed:    l.Config.GetSeedRules(),
 	}
 	l.transfers = append(l.transfers, t)
+	l.notify(EventAdded, t, nil)
+	l.save()
 	return *t, nil
 }
 
+// AddTorrent queues the .torrent file read from r, recording the user who added it (optional).
+// The file is kept in the torrents dir and the transfer ID is its info hash.
+func (l *Downloader) AddTorrent(r io.Reader, user string) (Transfer, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, httpReadLimit))
+	if err != nil {
+		return Transfer{}, err
+	}
+	metaInfo, err := metainfo.Load(bytes.NewReader(b))
+	if err != nil {
+		return Transfer{}, fmt.Errorf("invalid torrent file: %s", err)
+	}
+	id := metaInfo.HashInfoBytes().HexString()
+
+	dir := l.torrentsDir()
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return Transfer{}, err
+	}
+	filename := filepath.Join(dir, id+".torrent")
+
+	l.Lock("AddTorrent")
+	defer l.Unlock("AddTorrent")
+
+	// already exists
+	if t, err := l.findByID(id); err == nil {
+		return *t, nil
+	}
+
+	if err := overwrite(filename, b, 0640); err != nil {
+		return Transfer{}, err
+	}
+	t := &Transfer{
+		ID:      id,
+		URL:     &url.URL{Scheme: "file", Path: filename},
+		User:    user,
+		Created: time.Now(),
+		Seed:    l.Config.GetSeedRules(),
+	}
+	l.transfers = append(l.transfers, t)
+	l.notify(EventAdded, t, nil)
+	l.save()
+	return *t, nil
+}
+
+// torrentsDir is where uploaded .torrent files are kept until their transfer is done.
+func (l *Downloader) torrentsDir() string {
+	return filepath.Join(l.Config.GetDownloadDir(), ".torrents")
+}
+
+// uploaded returns true if the transfer is of a .torrent file uploaded to the torrents dir.
+// The caller must hold the lock.
+func (l *Downloader) uploaded(t *Transfer) bool {
+	return t.URL.Scheme == "file" && filepath.Dir(t.URL.Path) == l.torrentsDir()
+}
+
+// removeTorrentFile removes the uploaded .torrent file of a transfer, if it has one.
+// The caller must hold the lock.
+func (l *Downloader) removeTorrentFile(t *Transfer) {
+	if !l.uploaded(t) {
+		return
+	}
+	if err := os.Remove(t.URL.Path); err != nil && !os.IsNotExist(err) {
+		l.Config.Logger.Warnf("removing torrent file failed: %s", err)
+	}
+}
+
 func (l *Downloader) Remove(id string) error {
 	l.Lock("Remove")
 	defer l.Unlock("Remove")
@@ -819,20 +1218,23 @@ func (l *Downloader) Remove(id string) error {
 	// Drop torrent.
 	if t.Torrent != nil {
 		t.Torrent.Drop()
+	}
 
-		// If it's uploading, do NOT delete it (it's complete).
-		if !t.Uploading {
-			// Clean up the download dir, if it exists.
-			if _, err := os.Stat(t.DownloadDir); err == nil {
-				if err := os.RemoveAll(t.DownloadDir); err != nil {
-					return err
-				}
+	// Clean up partial torrent and paused downloads.
+	// If it's uploading, do NOT delete it (it's complete).
+	if (t.Torrent != nil || t.Paused || t.Held) && !t.Uploading && t.DownloadDir != "" {
+		// Clean up the download dir, if it exists.
+		if _, err := os.Stat(t.DownloadDir); err == nil {
+			if err := os.RemoveAll(t.DownloadDir); err != nil {
+				return err
 			}
 		}
 	}
 
 	// Take it out of the transfer list
+	l.removeTorrentFile(t)
 	l.remove(id)
+	l.save()
 
 	// Unmark
 	if err := t.UnmarkDownloading(); err != nil {
@@ -885,10 +1287,10 @@ func (t Transfer) String() string {
 // TotalSeedSize returns the length of the seeding target size in bytes.
 func (t Transfer) TotalSeedSize() int64 {
 	if t.Torrent != nil {
-		if t.SeedRatio <= 0 {
+		if t.Seed.Ratio <= 0 {
 			return 0
 		}
-		return int64(float64(t.TotalSize()) * t.SeedRatio)
+		return int64(float64(t.TotalSize()) * t.Seed.Ratio)
 	}
 	return 0
 }
@@ -906,17 +1308,23 @@ func (t Transfer) Files() ([]os.FileInfo, error) {
 	return find(t.DownloadDir)
 }
 
-// DownloadedBytes returns the downloaded bytes.
+// DownloadedBytes returns the downloaded bytes of the selected files.
 func (t Transfer) DownloadedBytes() int64 {
 	if t.Torrent != nil {
 		start := time.Now()
-		size := t.Torrent.BytesCompleted()
+		var size int64
+		if t.Torrent.Info() != nil {
+			size = t.selectedCompleted()
+		}
 		seconds := time.Since(start).Seconds()
 		if seconds > 0.2 {
 			log.Debugf("DownloadedBytes took %.2f seconds", seconds)
 		}
 		return size
 	}
+	if t.received != nil {
+		return atomic.LoadInt64(t.received)
+	}
 	if t.DownloadDir != "" {
 		n, _ := du(t.DownloadDir)
 		return n
@@ -924,7 +1332,7 @@ func (t Transfer) DownloadedBytes() int64 {
 	return 0
 }
 
-// TotalSize returns the completed size of the download in bytes.
+// TotalSize returns the completed size of the selected files in bytes.
 func (t Transfer) TotalSize() int64 {
 	if t.DownloadSize > 0 {
 		return t.DownloadSize
@@ -933,7 +1341,7 @@ func (t Transfer) TotalSize() int64 {
 		start := time.Now()
 		var size int64
 		if info := t.Torrent.Info(); info != nil {
-			size = info.TotalLength()
+			size = t.selectedSize()
 		}
 
 		seconds := time.Since(start).Seconds()
@@ -945,6 +1353,32 @@ func (t Transfer) TotalSize() int64 {
 	return 0
 }
 
+// State returns the state of the transfer.
+func (t Transfer) State() string {
+	if t.IsCompleted() {
+		if t.Error != nil {
+			return StateFailed
+		}
+		return StateCompleted
+	}
+	if t.Paused {
+		return StatePaused
+	}
+	if t.Held {
+		return StateHeld
+	}
+	if t.Uploading {
+		return StateSeeding
+	}
+	if t.Selecting {
+		return StateSelecting
+	}
+	if t.IsActive() {
+		return StateActive
+	}
+	return StatePending
+}
+
 // IsActive returns true when the transfer is started but not completed.
 func (t Transfer) IsActive() bool {
 	return t.IsStarted() && !t.IsCompleted()
@@ -1031,6 +1465,78 @@ func GET(ctx context.Context, rawurl string) (*http.Response, error) {
 	return res, nil
 }
 
+// getRange requests the content starting at offset.
+func getRange(ctx context.Context, rawurl string, offset int64) (*http.Response, error) {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 400 {
+		res.Body.Close()
+		return nil, fmt.Errorf("request failed: %s", http.StatusText(res.StatusCode))
+	}
+	return res, nil
+}
+
+// Retries of failed network requests.
+var (
+	retryAttempts       = 5
+	retryBackoff        = 2 * time.Second
+	retryBackoffMaximum = 1 * time.Minute
+)
+
+// retry calls fn until it succeeds, waiting longer after each failure.
+func retry(ctx context.Context, logger *zap.SugaredLogger, fn func() error) error {
+	wait := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || attempt >= retryAttempts {
+			return err
+		}
+		logger.Warnf("attempt %d of %d failed, retrying in %s: %s", attempt, retryAttempts, wait, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > retryBackoffMaximum {
+			wait = retryBackoffMaximum
+		}
+	}
+}
+
+// verifyFile checks the file against its SHA-256 hash.
+func verifyFile(filename, hash string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != hash {
+		return fmt.Errorf("hash mismatch for %q: got %s, want %s", filename, sum, hash)
+	}
+	return nil
+}
+
 func du(path string) (int64, error) {
 	var size int64
 	err := filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
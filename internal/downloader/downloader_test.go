@@ -0,0 +1,98 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A single file torrent of one piece.
+const testTorrent = "d8:announce35:udp://tracker.example.com:80/announce4:infod6:lengthi4e4:name9:movie.mp412:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaaee"
+
+func TestAddSchemes(t *testing.T) {
+	l := newTestDownloader(t)
+	defer os.RemoveAll(l.Config.DownloadDir)
+
+	for _, rawurl := range []string{
+		"magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567",
+		"http://example.com/movie.mp4",
+		"https://example.com/movie.torrent",
+	} {
+		if _, err := l.Add(rawurl, ""); err != nil {
+			t.Errorf("%q: %s", rawurl, err)
+		}
+	}
+	for _, rawurl := range []string{
+		"",
+		"file:///etc/passwd",
+		"file://" + filepath.Join(l.torrentsDir(), "upload.torrent"),
+		"/etc/passwd",
+		"ftp://example.com/movie.mp4",
+	} {
+		if _, err := l.Add(rawurl, ""); err == nil {
+			t.Errorf("%q: expected an error", rawurl)
+		}
+	}
+	if len(l.transfers) != 3 {
+		t.Errorf("%d transfers queued, want 3", len(l.transfers))
+	}
+}
+
+func TestAddTorrent(t *testing.T) {
+	l := newTestDownloader(t)
+	defer os.RemoveAll(l.Config.DownloadDir)
+
+	tr, err := l.AddTorrent(strings.NewReader(testTorrent), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.URL.Scheme != "file" || !l.uploaded(&tr) {
+		t.Fatalf("transfer URL %q isn't in the torrents dir", tr.URL)
+	}
+	b, err := ioutil.ReadFile(tr.URL.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte(testTorrent)) {
+		t.Error("stored torrent differs from the upload")
+	}
+
+	// Adding it again finds the same transfer.
+	again, err := l.AddTorrent(strings.NewReader(testTorrent), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != tr.ID || len(l.transfers) != 1 {
+		t.Errorf("adding the torrent again made another transfer")
+	}
+
+	if _, err := l.AddTorrent(strings.NewReader("not a torrent"), "alice"); err == nil {
+		t.Error("expected an error for an invalid torrent")
+	}
+}
+
+func TestTransferTorrentFileOutsideTorrentsDir(t *testing.T) {
+	l := newTestDownloader(t)
+	defer os.RemoveAll(l.Config.DownloadDir)
+
+	// A torrent file anywhere else, as a transfer restored from an old store could have.
+	filename := filepath.Join(l.Config.DownloadDir, "elsewhere.torrent")
+	if err := ioutil.WriteFile(filename, []byte(testTorrent), 0640); err != nil {
+		t.Fatal(err)
+	}
+	tr := addTestTransfer(l, "a", 0)
+	tr.URL = &url.URL{Scheme: "file", Path: filename}
+
+	err := l.transferTorrent(context.Background(), tr)
+	if err == nil || !strings.Contains(err.Error(), "not an upload") {
+		t.Fatalf("got %v, want the file refused", err)
+	}
+	if tr.Torrent != nil {
+		t.Error("torrent was added")
+	}
+}
@@ -0,0 +1,40 @@
+package downloader
+
+// Transfer events, passed to Config.Events.
+const (
+	EventAdded     = "transfer.added"
+	EventCompleted = "transfer.completed"
+	EventFailed    = "transfer.failed"
+	EventSeeded    = "transfer.seeded"
+)
+
+// Event describes something that happened to a transfer.
+type Event struct {
+	Type  string
+	ID    string
+	Name  string
+	URL   string
+	User  string
+	Dir   string
+	Size  int64
+	Error error
+}
+
+// notify sends an event about the transfer to Config.Events, if set.
+// The caller must hold the lock.
+func (l *Downloader) notify(typ string, t *Transfer, err error) {
+	if l.Config.Events == nil {
+		return
+	}
+	ev := Event{
+		Type:  typ,
+		ID:    t.ID,
+		Name:  t.String(),
+		URL:   t.URL.String(),
+		User:  t.User,
+		Dir:   t.DownloadDir,
+		Size:  t.TotalSize(),
+		Error: err,
+	}
+	go l.Config.Events(ev)
+}
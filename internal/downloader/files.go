@@ -0,0 +1,187 @@
+package downloader
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// File priorities within a torrent.
+const (
+	PrioritySkip   = 0
+	PriorityNormal = 1
+	PriorityHigh   = 2
+)
+
+// TransferFile is a file inside a torrent transfer.
+type TransferFile struct {
+	Index     int
+	Path      string
+	Length    int64
+	Completed int64
+	Priority  int
+}
+
+// Skipped returns true when the file is not selected for download.
+func (f TransferFile) Skipped() bool {
+	return f.Priority == PrioritySkip
+}
+
+// priority returns the chosen priority of a file in the torrent.
+func (t Transfer) priority(path string) int {
+	if t.FilePriorities == nil {
+		return PriorityNormal
+	}
+	prio, ok := t.FilePriorities[path]
+	if !ok {
+		return PriorityNormal
+	}
+	return prio
+}
+
+// TorrentFiles returns the files of the torrent, once its info is known.
+func (t Transfer) TorrentFiles() []TransferFile {
+	if t.Torrent == nil || t.Torrent.Info() == nil {
+		return nil
+	}
+	var files []TransferFile
+	for i, f := range t.Torrent.Files() {
+		files = append(files, TransferFile{
+			Index:     i,
+			Path:      f.Path(),
+			Length:    f.Length(),
+			Completed: f.BytesCompleted(),
+			Priority:  t.priority(f.Path()),
+		})
+	}
+	return files
+}
+
+// selectedSize returns the total length of the selected files.
+func (t Transfer) selectedSize() int64 {
+	var size int64
+	for _, f := range t.Torrent.Files() {
+		if t.priority(f.Path()) == PrioritySkip {
+			continue
+		}
+		size += f.Length()
+	}
+	return size
+}
+
+// selectedCompleted returns the completed bytes of the selected files.
+func (t Transfer) selectedCompleted() int64 {
+	var size int64
+	for _, f := range t.Torrent.Files() {
+		if t.priority(f.Path()) == PrioritySkip {
+			continue
+		}
+		size += f.BytesCompleted()
+	}
+	return size
+}
+
+// applyPriorities sets the piece priorities of the torrent from the file selection.
+func (t Transfer) applyPriorities() {
+	for _, f := range t.Torrent.Files() {
+		switch t.priority(f.Path()) {
+		case PrioritySkip:
+			f.SetPriority(torrent.PiecePriorityNone)
+		case PriorityHigh:
+			f.SetPriority(torrent.PiecePriorityHigh)
+		default:
+			f.SetPriority(torrent.PiecePriorityNormal)
+		}
+	}
+}
+
+// waitForSelection blocks until the user has chosen the files to download.
+func (l *Downloader) waitForSelection(t *Transfer, done <-chan struct{}) bool {
+	l.Lock("selecting")
+	t.Selecting = true
+	l.Unlock("selecting")
+
+	defer func() {
+		l.Lock("selected")
+		t.Selecting = false
+		l.Unlock("selected")
+	}()
+
+	for {
+		l.RLock("selection")
+		selected := t.Selected
+		l.RUnlock("selection")
+		if selected {
+			return true
+		}
+		select {
+		case <-done:
+			return false
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// TorrentFiles returns the files of a torrent transfer.
+func (l *Downloader) TorrentFiles(id string) ([]TransferFile, error) {
+	l.RLock("TorrentFiles")
+	defer l.RUnlock("TorrentFiles")
+
+	t, err := l.findByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if t.Torrent == nil || t.Torrent.Info() == nil {
+		return nil, fmt.Errorf("torrent info is not available yet")
+	}
+	return t.TorrentFiles(), nil
+}
+
+// SetFilePriorities sets the priorities of files in a torrent transfer by index
+// and starts the download if it was waiting for a selection.
+func (l *Downloader) SetFilePriorities(id string, priorities map[int]int) error {
+	l.Lock("SetFilePriorities")
+	defer l.Unlock("SetFilePriorities")
+
+	t, err := l.findByID(id)
+	if err != nil {
+		return err
+	}
+	if t.Torrent == nil || t.Torrent.Info() == nil {
+		return fmt.Errorf("torrent info is not available yet")
+	}
+	if t.Uploading {
+		return fmt.Errorf("transfer is already seeding")
+	}
+
+	// Replace the map rather than modifying it, since copies of the transfer share it.
+	prios := make(map[string]int)
+	wanted := false
+	for i, f := range t.Torrent.Files() {
+		prio, ok := priorities[i]
+		if !ok {
+			prio = t.priority(f.Path())
+		}
+		if prio < PrioritySkip || prio > PriorityHigh {
+			return fmt.Errorf("invalid priority %d for %q", prio, f.Path())
+		}
+		if prio != PrioritySkip {
+			wanted = true
+		}
+		prios[f.Path()] = prio
+	}
+	// With nothing to download the transfer would complete right away.
+	if !wanted {
+		return fmt.Errorf("select at least one file to download")
+	}
+	t.FilePriorities = prios
+
+	// Selection changes apply right away to downloads in progress.
+	if t.Selected {
+		t.applyPriorities()
+	}
+	t.Selected = true
+	l.save()
+	return nil
+}
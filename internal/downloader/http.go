@@ -0,0 +1,333 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Plain HTTP downloads bigger than this are split into parallel segments.
+	httpSegmentMinimum int64 = 16 * (1024 * 1024)
+	httpSegments             = 4
+)
+
+// httpInfo describes the file behind an HTTP URL.
+type httpInfo struct {
+	Torrent  bool
+	Filename string
+	Size     int64
+	Ranges   bool
+}
+
+// segment is a byte range of a plain HTTP download.
+type segment struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Done  int64 `json:"done"`
+}
+
+func (s segment) Remaining() int64 {
+	return s.End - s.Start + 1 - s.Done
+}
+
+// probeHTTP finds out if the URL is a .torrent file or a file to download directly.
+func probeHTTP(ctx context.Context, rawurl string) (*httpInfo, error) {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Range", "bytes=0-63")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 400 {
+		return nil, fmt.Errorf("request failed: %s", http.StatusText(res.StatusCode))
+	}
+
+	info := &httpInfo{
+		Filename: path.Base(req.URL.Path),
+		Size:     res.ContentLength,
+	}
+	if cd := res.Header.Get("Content-Disposition"); cd != "" {
+		if _, params, err := mime.ParseMediaType(cd); err == nil && params["filename"] != "" {
+			info.Filename = filepath.Base(params["filename"])
+		}
+	}
+	if !safeFilename(info.Filename) {
+		info.Filename = req.URL.Host
+	}
+	if !safeFilename(info.Filename) {
+		info.Filename = "download"
+	}
+
+	// A partial response tells us the real size and that ranges work.
+	if res.StatusCode == http.StatusPartialContent {
+		info.Ranges = true
+		info.Size = -1
+		if cr := res.Header.Get("Content-Range"); cr != "" {
+			if i := strings.LastIndex(cr, "/"); i != -1 {
+				fmt.Sscanf(cr[i+1:], "%d", &info.Size)
+			}
+		}
+	}
+
+	// Torrent files are recognized by type, name or bencoded content.
+	ct, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	head, _ := ioutil.ReadAll(io.LimitReader(res.Body, 64))
+	switch {
+	case ct == "application/x-bittorrent":
+		info.Torrent = true
+	case strings.HasSuffix(strings.ToLower(info.Filename), ".torrent"):
+		info.Torrent = true
+	case ct == "" || ct == "application/octet-stream" || ct == "text/plain":
+		info.Torrent = bencoded(head)
+	}
+	return info, nil
+}
+
+// safeFilename returns true if the name, which comes from the server or the URL,
+// can name a download. The download dir is named after the name without its extension,
+// so hidden names are refused: they include "..", and names that are only an extension.
+func safeFilename(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\\")
+}
+
+// bencoded returns true if the data looks like the start of a bencoded dictionary.
+func bencoded(b []byte) bool {
+	if len(b) < 3 || b[0] != 'd' {
+		return false
+	}
+	i := 1
+	for i < len(b) && b[i] >= '0' && b[i] <= '9' {
+		i++
+	}
+	return i > 1 && i < len(b) && b[i] == ':'
+}
+
+func (l *Downloader) transferHTTP(ctx context.Context, t *Transfer, info *httpInfo) error {
+	l.RLock("http url")
+	rawurl := t.URL.String()
+	l.RUnlock("http url")
+
+	name := info.Filename
+	if !safeFilename(name) {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	dldir := filepath.Join(l.Config.GetDownloadDir(), strings.TrimSuffix(name, filepath.Ext(name)))
+	filename := filepath.Join(dldir, name)
+
+	// Ensure we have enough storage, not counting data from a previous run.
+	if info.Size > 0 {
+		remaining := info.Size
+		if n, err := du(dldir); err == nil {
+			remaining -= n
+		}
+		if !l.availableStorage(t, remaining) {
+			return ErrInsufficientStorage
+		}
+	}
+
+	l.Lock("transfer http id")
+	t.DownloadID = filepath.Base(dldir)
+	t.DownloadDir = dldir
+	if info.Size > 0 {
+		t.DownloadSize = info.Size
+	}
+	l.save()
+	l.Unlock("transfer http id")
+
+	// Mark the transfer as downloading.
+	if err := t.MarkDownloading(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dldir, 0750); err != nil {
+		return err
+	}
+
+	l.Config.Logger.Debugf("Downloading file %s %s", name, rawurl)
+
+	if info.Ranges && info.Size >= httpSegmentMinimum {
+		received := new(int64)
+		l.Lock("transfer http progress")
+		t.received = received
+		l.Unlock("transfer http progress")
+
+		if err := l.segmentedCopy(ctx, rawurl, filename, info.Size, received); err != nil {
+			return err
+		}
+	} else {
+		err := retry(ctx, l.Config.Logger, func() error {
+			return l.resumeCopy(ctx, rawurl, filename, info.Size)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return l.complete(ctx, t)
+}
+
+// segmentedCopy downloads the file in parallel byte ranges.
+// Progress is kept in a hidden file next to the download, so it can be resumed.
+func (l *Downloader) segmentedCopy(ctx context.Context, rawurl, filename string, size int64, received *int64) error {
+	statefile := filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+".segments")
+
+	var segments []segment
+	if b, err := ioutil.ReadFile(statefile); err == nil {
+		if err := json.Unmarshal(b, &segments); err != nil {
+			segments = nil
+		}
+	}
+	if len(segments) == 0 || segments[len(segments)-1].End != size-1 {
+		segments = nil
+		n := size / int64(httpSegments)
+		for i := 0; i < httpSegments; i++ {
+			s := segment{Start: int64(i) * n, End: int64(i+1)*n - 1}
+			if i == httpSegments-1 {
+				s.End = size - 1
+			}
+			segments = append(segments, s)
+		}
+	}
+	for _, s := range segments {
+		atomic.AddInt64(received, s.Done)
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("create %q failed: %s", filename, err)
+	}
+	defer f.Close()
+
+	var mu sync.Mutex
+	save := func() {
+		mu.Lock()
+		b, err := json.Marshal(segments)
+		mu.Unlock()
+		if err != nil {
+			return
+		}
+		if err := overwrite(statefile, b, 0640); err != nil {
+			l.Config.Logger.Warnf("saving download state failed: %s", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Save progress regularly while the segments download.
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				save()
+			}
+		}
+	}()
+
+	errs := make(chan error, len(segments))
+	for i := range segments {
+		go func(s *segment) {
+			errs <- retry(ctx, l.Config.Logger, func() error {
+				return l.copySegment(ctx, rawurl, f, s, &mu, received)
+			})
+		}(&segments[i])
+	}
+
+	var firstErr error
+	for range segments {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	close(done)
+
+	if firstErr != nil {
+		save()
+		return firstErr
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(statefile)
+}
+
+// copySegment downloads the rest of a segment into the file.
+func (l *Downloader) copySegment(ctx context.Context, rawurl string, f *os.File, s *segment, mu *sync.Mutex, received *int64) error {
+	mu.Lock()
+	offset := s.Start + s.Done
+	remaining := s.Remaining()
+	mu.Unlock()
+	if remaining <= 0 {
+		return nil
+	}
+
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+remaining-1))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("range request failed: %s", res.Status)
+	}
+
+	body := l.throttle(ctx, res.Body)
+	buf := make([]byte, 32*1024)
+	for remaining > 0 {
+		n, err := body.Read(buf)
+		if int64(n) > remaining {
+			n = int(remaining)
+		}
+		if n > 0 {
+			if _, err := f.WriteAt(buf[:n], offset); err != nil {
+				return err
+			}
+			offset += int64(n)
+			remaining -= int64(n)
+
+			mu.Lock()
+			s.Done += int64(n)
+			mu.Unlock()
+			atomic.AddInt64(received, int64(n))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if remaining > 0 {
+		return fmt.Errorf("segment ended early with %d bytes remaining", remaining)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestProbeHTTPFilename(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cd := r.URL.Query().Get("cd"); cd != "" {
+			w.Header().Set("Content-Disposition", cd)
+		}
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write([]byte("not a torrent"))
+	}))
+	defer ts.Close()
+	host := mustParse(t, ts.URL).Host
+
+	tests := []struct {
+		path string
+		cd   string
+		want string
+	}{
+		{"/movie.mp4", "", "movie.mp4"},
+		{"/file", `attachment; filename="movie.mkv"`, "movie.mkv"},
+		{"/file", `attachment; filename="../../etc/movie.mkv"`, "movie.mkv"},
+		{"/file", `attachment; filename=".."`, host},
+		{"/file", `attachment; filename=".mp4"`, host},
+		{"/file", `attachment; filename=".hidden"`, host},
+		{"/.mp4", "", host},
+		{"/", "", host},
+	}
+	for _, test := range tests {
+		rawurl := ts.URL + test.path
+		if test.cd != "" {
+			rawurl += "?cd=" + url.QueryEscape(test.cd)
+		}
+		info, err := probeHTTP(context.Background(), rawurl)
+		if err != nil {
+			t.Fatalf("%s %q: %s", test.path, test.cd, err)
+		}
+		if info.Filename != test.want {
+			t.Errorf("%s %q: filename is %q, want %q", test.path, test.cd, info.Filename, test.want)
+		}
+		if info.Torrent {
+			t.Errorf("%s %q: taken for a torrent", test.path, test.cd)
+		}
+	}
+}
+
+func TestProbeHTTPTorrent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte("d8:announce35:udp://tracker.example.com:80/announcee"))
+	}))
+	defer ts.Close()
+
+	info, err := probeHTTP(context.Background(), ts.URL+"/download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Torrent {
+		t.Error("bencoded response not taken for a torrent")
+	}
+}
+
+func TestSafeFilename(t *testing.T) {
+	for name, want := range map[string]bool{
+		"movie.mp4":   true,
+		"movie":       true,
+		"example.com": true,
+		"":            false,
+		".":           false,
+		"..":          false,
+		".mp4":        false,
+		"a/b.mp4":     false,
+		`a\b.mp4`:     false,
+	} {
+		if got := safeFilename(name); got != want {
+			t.Errorf("safeFilename(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func mustParse(t *testing.T, rawurl string) *url.URL {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
@@ -0,0 +1,115 @@
+package downloader
+
+import (
+	"fmt"
+	"sort"
+)
+
+// queue returns the transfers waiting for a slot, highest priority first.
+// Transfers with the same priority keep their order in the list.
+// The caller must hold the lock.
+func (l *Downloader) queue() []*Transfer {
+	var queue []*Transfer
+	for _, t := range l.transfers {
+		if t.IsStarted() || t.IsCompleted() || t.Paused || t.Held {
+			continue
+		}
+		queue = append(queue, t)
+	}
+	sort.SliceStable(queue, func(i, j int) bool { return queue[i].Priority > queue[j].Priority })
+	return queue
+}
+
+// Pause stops a transfer and frees its slot, keeping the data downloaded so far.
+func (l *Downloader) Pause(id string) error {
+	l.Lock("Pause")
+	defer l.Unlock("Pause")
+
+	t, err := l.findByID(id)
+	if err != nil {
+		return err
+	}
+	if t.IsCompleted() {
+		return fmt.Errorf("transfer is already completed")
+	}
+	if t.Paused {
+		return nil
+	}
+	t.Paused = true
+
+	// The transfer goroutine puts it back in the queue when it stops.
+	if t.Cancel != nil {
+		cancel := *t.Cancel
+		cancel()
+	}
+	l.save()
+	return nil
+}
+
+// Resume queues a paused transfer again.
+func (l *Downloader) Resume(id string) error {
+	l.Lock("Resume")
+	defer l.Unlock("Resume")
+
+	t, err := l.findByID(id)
+	if err != nil {
+		return err
+	}
+	t.Paused = false
+	l.save()
+	return nil
+}
+
+// SetPriority sets the queue priority of a transfer.
+func (l *Downloader) SetPriority(id string, priority int) error {
+	l.Lock("SetPriority")
+	defer l.Unlock("SetPriority")
+
+	t, err := l.findByID(id)
+	if err != nil {
+		return err
+	}
+	t.Priority = priority
+	l.save()
+	return nil
+}
+
+// MoveTop moves a transfer to the front of the queue.
+func (l *Downloader) MoveTop(id string) error {
+	l.Lock("MoveTop")
+	defer l.Unlock("MoveTop")
+
+	t, err := l.findByID(id)
+	if err != nil {
+		return err
+	}
+	for _, other := range l.transfers {
+		if other.Priority > t.Priority {
+			t.Priority = other.Priority
+		}
+	}
+	l.remove(id)
+	l.transfers = append([]*Transfer{t}, l.transfers...)
+	l.save()
+	return nil
+}
+
+// MoveBottom moves a transfer to the back of the queue.
+func (l *Downloader) MoveBottom(id string) error {
+	l.Lock("MoveBottom")
+	defer l.Unlock("MoveBottom")
+
+	t, err := l.findByID(id)
+	if err != nil {
+		return err
+	}
+	for _, other := range l.transfers {
+		if other.Priority < t.Priority {
+			t.Priority = other.Priority
+		}
+	}
+	l.remove(id)
+	l.transfers = append(l.transfers, t)
+	l.save()
+	return nil
+}
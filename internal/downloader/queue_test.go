@@ -0,0 +1,142 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestDownloader returns a downloader without a torrent client, storing its state
+// in a temp dir that the caller removes.
+func newTestDownloader(t *testing.T) *Downloader {
+	dir, err := ioutil.TempDir("", "downloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Downloader{
+		Config: &Config{
+			DownloadDir: dir,
+			Logger:      zap.NewNop().Sugar(),
+		},
+	}
+}
+
+func addTestTransfer(l *Downloader, id string, priority int) *Transfer {
+	t := &Transfer{
+		ID:       id,
+		URL:      &url.URL{Scheme: "magnet", Opaque: "?xt=urn:btih:" + id},
+		Created:  time.Now(),
+		Priority: priority,
+	}
+	l.transfers = append(l.transfers, t)
+	return t
+}
+
+// start marks the transfer as started the way the manager does, and returns its context.
+func start(l *Downloader, t *Transfer) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Started = time.Now()
+	t.Cancel = &cancel
+	return ctx
+}
+
+func queueIDs(l *Downloader) []string {
+	var ids []string
+	for _, t := range l.queue() {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+func TestQueueOrder(t *testing.T) {
+	l := newTestDownloader(t)
+	defer os.RemoveAll(l.Config.DownloadDir)
+	addTestTransfer(l, "low", 0)
+	addTestTransfer(l, "high", 1)
+	addTestTransfer(l, "low2", 0)
+	start(l, addTestTransfer(l, "started", 2))
+	addTestTransfer(l, "paused", 2).Paused = true
+	addTestTransfer(l, "held", 2).Held = true
+	addTestTransfer(l, "completed", 2).Completed = time.Now()
+
+	got := queueIDs(l)
+	want := []string{"high", "low", "low2"}
+	if len(got) != len(want) {
+		t.Fatalf("queue is %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("queue is %v, want %v", got, want)
+		}
+	}
+}
+
+func TestPauseRequeues(t *testing.T) {
+	l := newTestDownloader(t)
+	defer os.RemoveAll(l.Config.DownloadDir)
+	tr := addTestTransfer(l, "a", 0)
+	ctx := start(l, tr)
+
+	if err := l.Pause("a"); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("pausing didn't cancel the transfer")
+	}
+	l.finish(ctx, tr, context.Canceled)
+
+	if tr.IsCompleted() || tr.IsStarted() {
+		t.Fatalf("paused transfer is %s", tr.State())
+	}
+	if len(l.queue()) != 0 {
+		t.Fatal("paused transfer is queued")
+	}
+
+	if err := l.Resume("a"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := queueIDs(l); len(ids) != 1 || ids[0] != "a" {
+		t.Fatalf("queue after resuming is %v", ids)
+	}
+}
+
+func TestPauseResumeBeforeStop(t *testing.T) {
+	l := newTestDownloader(t)
+	defer os.RemoveAll(l.Config.DownloadDir)
+	tr := addTestTransfer(l, "a", 0)
+	ctx := start(l, tr)
+
+	// Resuming right away, before the transfer goroutine has stopped.
+	if err := l.Pause("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Resume("a"); err != nil {
+		t.Fatal(err)
+	}
+	l.finish(ctx, tr, context.Canceled)
+
+	if tr.IsCompleted() {
+		t.Fatalf("transfer was dropped as %s", tr.State())
+	}
+	if ids := queueIDs(l); len(ids) != 1 || ids[0] != "a" {
+		t.Fatalf("queue after pausing and resuming is %v", ids)
+	}
+}
+
+func TestFinishFailed(t *testing.T) {
+	l := newTestDownloader(t)
+	defer os.RemoveAll(l.Config.DownloadDir)
+	tr := addTestTransfer(l, "a", 0)
+	ctx := start(l, tr)
+
+	l.finish(ctx, tr, errors.New("tracker is down"))
+	if tr.State() != StateFailed {
+		t.Fatalf("transfer is %s, want %s", tr.State(), StateFailed)
+	}
+}
@@ -0,0 +1,72 @@
+package downloader
+
+import (
+	"fmt"
+	"time"
+)
+
+// SeedRules decide when a torrent stops seeding.
+type SeedRules struct {
+	// Ratio of uploaded to downloaded bytes to reach. Zero disables seeding,
+	// unless there's a minimum seed time, and a negative ratio is unlimited.
+	Ratio float64
+
+	// MinTime is how long to seed at least, even after the ratio is reached.
+	MinTime time.Duration
+
+	// MaxTime is how long to seed at most (optional).
+	MaxTime time.Duration
+
+	// IdleTime stops seeding after this long without peers (optional).
+	IdleTime time.Duration
+}
+
+// Seeds returns true if the torrent is seeded at all.
+func (r SeedRules) Seeds() bool {
+	return r.Ratio != 0 || r.MinTime > 0
+}
+
+// done returns true and the reason when seeding should stop.
+func (r SeedRules) done(ratio float64, seeded, idle time.Duration) (bool, string) {
+	if seeded < r.MinTime {
+		return false, ""
+	}
+	switch {
+	case r.Ratio == 0:
+		return true, "minimum seed time reached"
+	case r.Ratio > 0 && ratio >= r.Ratio:
+		return true, fmt.Sprintf("ratio %.2f reached", ratio)
+	case r.MaxTime > 0 && seeded >= r.MaxTime:
+		return true, "maximum seed time reached"
+	case r.IdleTime > 0 && idle >= r.IdleTime:
+		return true, fmt.Sprintf("no peers for %s", r.IdleTime)
+	}
+	return false, ""
+}
+
+// Ratio returns the upload ratio of the selected files.
+func (t Transfer) Ratio() float64 {
+	written := t.UploadedBytes()
+	size := t.TotalSize()
+	if written <= 0 || size <= 0 {
+		return 0
+	}
+	return float64(written) / float64(size)
+}
+
+// SetSeedRules overrides the seeding rules of a transfer.
+func (l *Downloader) SetSeedRules(id string, rules SeedRules) error {
+	l.Lock("SetSeedRules")
+	defer l.Unlock("SetSeedRules")
+
+	t, err := l.findByID(id)
+	if err != nil {
+		return err
+	}
+	if rules.MinTime < 0 || rules.MaxTime < 0 || rules.IdleTime < 0 {
+		return fmt.Errorf("seed times can't be negative")
+	}
+	t.Seed = rules
+	l.save()
+	return nil
+}
@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	humanize "github.com/dustin/go-humanize"
+)
+
+// Held downloads continue once free space is this much above the watermark,
+// so they don't stop and start around it.
+const watermarkMargin = 1.25
+
+// Reserve sets aside storage for something other than a transfer, like a transcode,
+// until it is released.
+func (l *Downloader) Reserve(id string, size int64) error {
+	l.Lock("Reserve")
+	defer l.Unlock("Reserve")
+
+	delete(l.reserved, id)
+	if size >= l.available(nil) {
+		return ErrInsufficientStorage
+	}
+	l.reserved[id] = size
+	return nil
+}
+
+// Release frees storage set aside with Reserve.
+func (l *Downloader) Release(id string) {
+	l.Lock("Release")
+	defer l.Unlock("Release")
+	delete(l.reserved, id)
+}
+
+// Holding returns true while downloads are held for lack of storage.
+func (l *Downloader) Holding() bool {
+	l.RLock("Holding")
+	defer l.RUnlock("Holding")
+	return l.holding
+}
+
+// available returns the free storage that isn't committed to other transfers or
+// reservations, keeping 5% spare. The caller must hold the lock.
+func (l *Downloader) available(except *Transfer) int64 {
+	space := l.Config.Space()
+	space -= int64(float64(space) * 0.05) // reserve 5%
+	return space - l.committed(except)
+}
+
+// committed returns the bytes that active downloads still have to write, plus reservations.
+// The caller must hold the lock.
+func (l *Downloader) committed(except *Transfer) int64 {
+	var size int64
+	for _, t := range l.transfers {
+		if t == except || !t.IsActive() || t.Uploading || t.Paused || t.Held {
+			continue
+		}
+		if remaining := t.TotalSize() - t.DownloadedBytes(); remaining > 0 {
+			size += remaining
+		}
+	}
+	for _, n := range l.reserved {
+		size += n
+	}
+	return size
+}
+
+// hold stops downloads while free space is below the watermark, and lets them
+// continue once space is freed. It returns true if any transfer changed.
+// The caller must hold the lock.
+func (l *Downloader) hold() bool {
+	watermark := l.Config.GetMinFree()
+	free := l.Config.Space()
+
+	if l.holding && (watermark <= 0 || float64(free) >= float64(watermark)*watermarkMargin) {
+		l.holding = false
+		l.Config.Logger.Infof("free space is %s, resuming held downloads", humanize.Bytes(uint64(free)))
+		changed := false
+		for _, t := range l.transfers {
+			if t.Held {
+				t.Held = false
+				changed = true
+			}
+		}
+		return changed
+	}
+	if !l.holding && watermark > 0 && free < watermark {
+		l.holding = true
+		l.Config.Logger.Warnf("free space is %s, below %s, holding downloads", humanize.Bytes(uint64(free)), humanize.Bytes(uint64(watermark)))
+	}
+	if !l.holding {
+		return false
+	}
+
+	// Hold every download, including ones added since.
+	changed := false
+	for _, t := range l.transfers {
+		if t.Held || t.Uploading || t.Paused || t.IsCompleted() {
+			continue
+		}
+		t.Held = true
+		changed = true
+		// The transfer goroutine puts it back in the queue when it stops.
+		if t.Cancel != nil {
+			cancel := *t.Cancel
+			cancel()
+		}
+	}
+	return changed
+}
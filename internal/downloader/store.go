@@ -0,0 +1,175 @@
+package downloader
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Transfer states as recorded in the transfer store.
+const (
+	StatePending   = "pending"
+	StateActive    = "active"
+	StateSelecting = "selecting"
+	StatePaused    = "paused"
+	StateHeld      = "held"
+	StateSeeding   = "seeding"
+	StateCompleted = "completed"
+	StateFailed    = "failed"
+)
+
+// transferRecord is the durable form of a Transfer.
+type transferRecord struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	User        string    `json:"user,omitempty"`
+	State       string    `json:"state"`
+	SeedRatio   float64   `json:"seed_ratio"`
+	DownloadDir string    `json:"download_dir"`
+	Uploading   bool      `json:"uploading"`
+	Created     time.Time `json:"created"`
+	Started     time.Time `json:"started"`
+	Completed   time.Time `json:"completed"`
+
+	FilePriorities map[string]int `json:"file_priorities,omitempty"`
+	Selected       bool           `json:"selected"`
+	Paused         bool           `json:"paused"`
+	Priority       int            `json:"priority"`
+
+	SeedMinTime  time.Duration `json:"seed_min_time"`
+	SeedMaxTime  time.Duration `json:"seed_max_time"`
+	SeedIdleTime time.Duration `json:"seed_idle_time"`
+	Seeding      time.Time     `json:"seeding"`
+}
+
+// storefile returns the path to the transfer store.
+func (l *Downloader) storefile() string {
+	return filepath.Join(l.Config.GetDownloadDir(), ".transfers.json")
+}
+
+// save writes all unfinished transfers to the transfer store.
+// The caller must hold the lock.
+func (l *Downloader) save() {
+	records := []transferRecord{}
+	for _, t := range l.transfers {
+		if t.IsCompleted() {
+			continue
+		}
+		records = append(records, transferRecord{
+			ID:          t.ID,
+			URL:         t.URL.String(),
+			User:        t.User,
+			State:       t.State(),
+			SeedRatio:   t.Seed.Ratio,
+			DownloadDir: t.DownloadDir,
+			Uploading:   t.Uploading,
+			Created:     t.Created,
+			Started:     t.Started,
+			Completed:   t.Completed,
+
+			FilePriorities: t.FilePriorities,
+			Selected:       t.Selected,
+			Paused:         t.Paused,
+			Priority:       t.Priority,
+
+			SeedMinTime:  t.Seed.MinTime,
+			SeedMaxTime:  t.Seed.MaxTime,
+			SeedIdleTime: t.Seed.IdleTime,
+			Seeding:      t.Seeding,
+		})
+	}
+
+	b, err := json.MarshalIndent(records, "", "    ")
+	if err != nil {
+		l.Config.Logger.Error(err)
+		return
+	}
+	if err := overwrite(l.storefile(), b, 0640); err != nil {
+		l.Config.Logger.Errorf("saving transfers failed: %s", err)
+	}
+}
+
+// load reads the transfer store and queues every transfer found in it.
+// Transfers are queued as pending and started again by the manager;
+// seeding transfers go straight back to seeding once their data is checked.
+func (l *Downloader) load() error {
+	b, err := ioutil.ReadFile(l.storefile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var records []transferRecord
+	if err := json.Unmarshal(b, &records); err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		u, err := url.Parse(r.URL)
+		if err != nil {
+			l.Config.Logger.Errorf("skipping stored transfer %s: %s", r.ID, err)
+			continue
+		}
+		l.Config.Logger.Infof("resuming %s transfer %s %s", r.State, r.ID, u)
+		l.transfers = append(l.transfers, &Transfer{
+			ID:          r.ID,
+			URL:         u,
+			User:        r.User,
+			Created:     r.Created,
+			DownloadDir: r.DownloadDir,
+			Uploading:   r.Uploading,
+			Seed: SeedRules{
+				Ratio:    r.SeedRatio,
+				MinTime:  r.SeedMinTime,
+				MaxTime:  r.SeedMaxTime,
+				IdleTime: r.SeedIdleTime,
+			},
+			Seeding: r.Seeding,
+
+			FilePriorities: r.FilePriorities,
+			Selected:       r.Selected,
+			Paused:         r.Paused,
+			Priority:       r.Priority,
+		})
+	}
+	return nil
+}
+
+// resuming returns true if the directory belongs to a stored transfer.
+func (l *Downloader) resuming(dir string) bool {
+	for _, t := range l.transfers {
+		if t.DownloadDir != "" && t.DownloadDir == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func overwrite(filename string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
@@ -0,0 +1,320 @@
+// Package probe reads media info with ffprobe, and caches it next to the media file.
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Probing a file is given up after this long.
+var Timeout = 30 * time.Second
+
+// Codecs and pixel formats that browsers can play in an mp4.
+var (
+	StreamableVideoCodecs  = []string{"h264"}
+	StreamablePixelFormats = []string{"yuv420p", "yuvj420p"}
+	StreamableAudioCodecs  = []string{"aac", "mp3"}
+)
+
+// Probe runs ffprobe on the file.
+func Probe(ctx context.Context, filename string) (*Info, error) {
+	exe, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, exe,
+		"-i", filename,
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_format", "-show_streams",
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe %q failed: %s\n%s", filename, err, string(output))
+	}
+
+	var ffinfo Info
+	if err := json.Unmarshal(output, &ffinfo); err != nil {
+		return nil, err
+	}
+	return &ffinfo, nil
+}
+
+// Cachefile returns the path to the cached media info of the file.
+func Cachefile(filename string) string {
+	return filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+".probe.json")
+}
+
+// cache is the cached media info, with the size and modification time of the file it belongs to.
+type cache struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Info    *Info     `json:"info"`
+}
+
+// Cached returns the media info of the file, probing it only if it changed since it was cached.
+func Cached(filename string) (*Info, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if b, err := ioutil.ReadFile(Cachefile(filename)); err == nil {
+		var c cache
+		if err := json.Unmarshal(b, &c); err == nil && c.Info != nil && c.Size == fi.Size() && c.ModTime.Equal(fi.ModTime()) {
+			return c.Info, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	info, err := Probe(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(cache{Size: fi.Size(), ModTime: fi.ModTime(), Info: info})
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(Cachefile(filename), b, 0644); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Info is the output of ffprobe.
+type Info struct {
+	Format struct {
+		BitRate        string `json:"bit_rate"`
+		Duration       string `json:"duration"`
+		Filename       string `json:"filename"`
+		FormatLongName string `json:"format_long_name"`
+		FormatName     string `json:"format_name"`
+		NbPrograms     int    `json:"nb_programs"`
+		NbStreams      int    `json:"nb_streams"`
+		ProbeScore     int    `json:"probe_score"`
+		Size           string `json:"size"`
+		StartTime      string `json:"start_time"`
+		Tags           struct {
+			CompatibleBrands string `json:"compatible_brands"`
+			CreationTime     string `json:"creation_time"`
+			MajorBrand       string `json:"major_brand"`
+			MinorVersion     string `json:"minor_version"`
+		} `json:"tags"`
+	} `json:"format"`
+	Streams []Stream `json:"streams"`
+}
+
+// Stream is an audio, video, subtitle or data stream.
+type Stream struct {
+	AvgFrameRate       string `json:"avg_frame_rate"`
+	BitRate            string `json:"bit_rate"`
+	BitsPerRawSample   string `json:"bits_per_raw_sample"`
+	Channels           int    `json:"channels"`
+	ChromaLocation     string `json:"chroma_location"`
+	CodecLongName      string `json:"codec_long_name"`
+	CodecName          string `json:"codec_name"`
+	CodecTag           string `json:"codec_tag"`
+	CodecTagString     string `json:"codec_tag_string"`
+	CodecTimeBase      string `json:"codec_time_base"`
+	CodecType          string `json:"codec_type"`
+	CodedHeight        int    `json:"coded_height"`
+	CodedWidth         int    `json:"coded_width"`
+	ColorPrimaries     string `json:"color_primaries"`
+	ColorRange         string `json:"color_range"`
+	ColorSpace         string `json:"color_space"`
+	ColorTransfer      string `json:"color_transfer"`
+	DisplayAspectRatio string `json:"display_aspect_ratio"`
+	Disposition        struct {
+		AttachedPic     int `json:"attached_pic"`
+		CleanEffects    int `json:"clean_effects"`
+		Comment         int `json:"comment"`
+		Default         int `json:"default"`
+		Dub             int `json:"dub"`
+		Forced          int `json:"forced"`
+		HearingImpaired int `json:"hearing_impaired"`
+		Karaoke         int `json:"karaoke"`
+		Lyrics          int `json:"lyrics"`
+		Original        int `json:"original"`
+		TimedThumbnails int `json:"timed_thumbnails"`
+		VisualImpaired  int `json:"visual_impaired"`
+	} `json:"disposition"`
+	Duration          string `json:"duration"`
+	DurationTs        int    `json:"duration_ts"`
+	HasBFrames        int    `json:"has_b_frames"`
+	Height            int    `json:"height"`
+	Index             int    `json:"index"`
+	IsAvc             string `json:"is_avc"`
+	Level             int    `json:"level"`
+	NalLengthSize     string `json:"nal_length_size"`
+	NbFrames          string `json:"nb_frames"`
+	PixFmt            string `json:"pix_fmt"`
+	Profile           string `json:"profile"`
+	RFrameRate        string `json:"r_frame_rate"`
+	Refs              int    `json:"refs"`
+	SampleAspectRatio string `json:"sample_aspect_ratio"`
+	StartPts          int    `json:"start_pts"`
+	StartTime         string `json:"start_time"`
+	Tags              struct {
+		CreationTime string `json:"creation_time"`
+		HandlerName  string `json:"handler_name"`
+		Language     string `json:"language"`
+		Title        string `json:"title"`
+	} `json:"tags"`
+	TimeBase string `json:"time_base"`
+	Width    int    `json:"width"`
+}
+
+// Duration returns the length of the media.
+func (i *Info) Duration() time.Duration {
+	seconds, err := strconv.ParseFloat(i.Format.Duration, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// BitRate returns the overall bit rate in bits per second.
+func (i *Info) BitRate() int64 {
+	n, _ := strconv.ParseInt(i.Format.BitRate, 10, 64)
+	return n
+}
+
+// Video returns the first video stream, skipping cover art, or nil if there is none.
+func (i *Info) Video() *Stream {
+	for n, s := range i.Streams {
+		if s.CodecType == "video" && s.Disposition.AttachedPic == 0 {
+			return &i.Streams[n]
+		}
+	}
+	return nil
+}
+
+// Audio returns the audio streams.
+func (i *Info) Audio() []Stream {
+	return i.streams("audio")
+}
+
+// Subtitles returns the subtitle streams.
+func (i *Info) Subtitles() []Stream {
+	return i.streams("subtitle")
+}
+
+func (i *Info) streams(codecType string) []Stream {
+	var streams []Stream
+	for _, s := range i.Streams {
+		if s.CodecType == codecType {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+// Resolution returns the width and height of the video, like "1920x1080", or "" for audio.
+func (i *Info) Resolution() string {
+	v := i.Video()
+	if v == nil || v.Width == 0 || v.Height == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", v.Width, v.Height)
+}
+
+// AudioCodecs returns the distinct codecs of the audio streams.
+func (i *Info) AudioCodecs() []string {
+	var codecs []string
+	for _, s := range i.Audio() {
+		codecs = appendUnique(codecs, s.CodecName)
+	}
+	return codecs
+}
+
+// AudioLanguages returns the distinct languages of the audio streams that have one.
+func (i *Info) AudioLanguages() []string {
+	var langs []string
+	for _, s := range i.Audio() {
+		if lang := s.Language(); lang != "" {
+			langs = appendUnique(langs, lang)
+		}
+	}
+	return langs
+}
+
+// MaxChannels returns the most channels of any audio stream.
+func (i *Info) MaxChannels() int {
+	max := 0
+	for _, s := range i.Audio() {
+		if s.Channels > max {
+			max = s.Channels
+		}
+	}
+	return max
+}
+
+// VideoStreamable returns true if there is no video, or browsers can play it as it is.
+func (i *Info) VideoStreamable() bool {
+	v := i.Video()
+	if v == nil {
+		return true
+	}
+	return contains(StreamableVideoCodecs, v.CodecName) && (v.PixFmt == "" || contains(StreamablePixelFormats, v.PixFmt))
+}
+
+// AudioStreamable returns true if browsers can play all of the audio streams as they are.
+func (i *Info) AudioStreamable() bool {
+	for _, codec := range i.AudioCodecs() {
+		if !contains(StreamableAudioCodecs, codec) {
+			return false
+		}
+	}
+	return true
+}
+
+// Language returns the language of the stream, or "" if it isn't known.
+func (s Stream) Language() string {
+	lang := strings.ToLower(s.Tags.Language)
+	if lang == "und" {
+		return ""
+	}
+	return lang
+}
+
+// String describes a subtitle stream, like "eng (forced)".
+func (s Stream) String() string {
+	name := s.Language()
+	if name == "" {
+		name = s.Tags.Title
+	}
+	if name == "" {
+		name = fmt.Sprintf("#%d", s.Index)
+	}
+	if s.Disposition.Forced == 1 {
+		name += " (forced)"
+	}
+	return name
+}
+
+func appendUnique(list []string, s string) []string {
+	if contains(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
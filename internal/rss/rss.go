@@ -0,0 +1,174 @@
+package rss
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The read limit on feed responses.
+var httpReadLimit int64 = 10 * (1024 * 1024)
+
+// Item is a torrent found in an RSS or Atom feed.
+type Item struct {
+	ID        string
+	Title     string
+	Link      string
+	Size      int64
+	Published time.Time
+}
+
+type enclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type attr struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type rssItem struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	GUID          string      `xml:"guid"`
+	PubDate       string      `xml:"pubDate"`
+	Enclosures    []enclosure `xml:"enclosure"`
+	MagnetURI     string      `xml:"magnetURI"`
+	ContentLength int64       `xml:"contentLength"`
+	Attrs         []attr      `xml:"attr"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Length int64  `xml:"length,attr"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+}
+
+type document struct {
+	Items   []rssItem   `xml:"channel>item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// Fetch downloads and parses an RSS or Atom feed.
+func Fetch(ctx context.Context, rawurl string) ([]Item, error) {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 400 {
+		return nil, fmt.Errorf("request failed: %s", http.StatusText(res.StatusCode))
+	}
+	return Parse(io.LimitReader(res.Body, httpReadLimit))
+}
+
+// Parse reads the items of an RSS or Atom feed.
+func Parse(r io.Reader) ([]Item, error) {
+	var doc document
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var items []Item
+	for _, i := range doc.Items {
+		item := Item{
+			ID:    i.GUID,
+			Title: strings.TrimSpace(i.Title),
+			Link:  strings.TrimSpace(i.Link),
+			Size:  i.ContentLength,
+		}
+		if t, err := time.Parse(time.RFC1123Z, i.PubDate); err == nil {
+			item.Published = t
+		} else if t, err := time.Parse(time.RFC1123, i.PubDate); err == nil {
+			item.Published = t
+		}
+
+		// Prefer a torrent enclosure over the item link.
+		for _, e := range i.Enclosures {
+			if e.URL == "" {
+				continue
+			}
+			item.Link = e.URL
+			if e.Length > 0 {
+				item.Size = e.Length
+			}
+			if e.Type == "application/x-bittorrent" {
+				break
+			}
+		}
+		// Torznab size attribute.
+		for _, a := range i.Attrs {
+			if a.Name == "size" {
+				if n, err := strconv.ParseInt(a.Value, 10, 64); err == nil {
+					item.Size = n
+				}
+			}
+		}
+		// Magnet links beat everything.
+		if i.MagnetURI != "" {
+			item.Link = strings.TrimSpace(i.MagnetURI)
+		}
+		items = append(items, item)
+	}
+
+	for _, e := range doc.Entries {
+		item := Item{
+			ID:    e.ID,
+			Title: strings.TrimSpace(e.Title),
+		}
+		if t, err := time.Parse(time.RFC3339, e.Updated); err == nil {
+			item.Published = t
+		}
+		for _, l := range e.Links {
+			if l.Href == "" {
+				continue
+			}
+			if item.Link == "" || l.Rel == "enclosure" || strings.HasPrefix(l.Href, "magnet:") {
+				item.Link = l.Href
+				if l.Length > 0 {
+					item.Size = l.Length
+				}
+			}
+		}
+		items = append(items, item)
+	}
+
+	// Items without an ID are identified by their link.
+	var found []Item
+	for _, item := range items {
+		if item.Link == "" {
+			continue
+		}
+		if item.ID == "" {
+			item.ID = item.Link
+		}
+		found = append(found, item)
+	}
+	return found, nil
+}
@@ -0,0 +1,119 @@
+package transcoder
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/viewscreen/viewscreen/internal/probe"
+)
+
+// HLSPlaylist is the name of the master playlist in an HLS package.
+const HLSPlaylist = "master.m3u8"
+
+// Seconds of video per HLS segment.
+var hlsSegmentTime = 6
+
+// Rendition is one quality level of an HLS package.
+type Rendition struct {
+	Height       int
+	VideoBitrate int // kbit/s
+}
+
+// HLSRenditions are the quality levels of an HLS package, from best to worst.
+// Levels taller than the source are left out.
+var HLSRenditions = []Rendition{
+	{Height: 1080, VideoBitrate: 5000},
+	{Height: 720, VideoBitrate: 2800},
+	{Height: 480, VideoBitrate: 1400},
+}
+
+// HLSDir returns the directory the source file is packaged into, hidden next to it.
+func HLSDir(srcname string) string {
+	return filepath.Join(filepath.Dir(srcname), ".hls", filepath.Base(srcname))
+}
+
+// renditions returns the quality levels for a video of the height, or just its own size if it's smaller than all of them.
+func renditions(height int) []Rendition {
+	var list []Rendition
+	for _, r := range HLSRenditions {
+		if r.Height <= height {
+			list = append(list, r)
+		}
+	}
+	if len(list) == 0 {
+		last := HLSRenditions[len(HLSRenditions)-1]
+		list = append(list, Rendition{Height: height - height%2, VideoBitrate: last.VideoBitrate})
+	}
+	return list
+}
+
+// hlsArgs returns the ffmpeg arguments to package the source as HLS into the directory,
+// with a master playlist and a variant playlist and segments per rendition.
+func (p Profile) hlsArgs(info *probe.Info, dir string) ([]string, error) {
+	v := info.Video()
+	if v == nil || v.Height == 0 {
+		return nil, fmt.Errorf("no video to package as HLS")
+	}
+	list := renditions(v.Height)
+	audio := len(info.Audio()) > 0 && p.AudioCodec != "none"
+
+	// Scale one decoded video into each rendition.
+	var outputs []string
+	for i := range list {
+		outputs = append(outputs, fmt.Sprintf("[v%d]", i))
+	}
+	filter := fmt.Sprintf("[0:v:0]split=%d%s", len(list), strings.Join(outputs, ""))
+	for i, r := range list {
+		filter += fmt.Sprintf(";[v%d]scale=-2:%d[v%dout]", i, r.Height, i)
+	}
+
+	args := []string{"-filter_complex", filter}
+	var streams []string
+	for i, r := range list {
+		args = append(args,
+			"-map", fmt.Sprintf("[v%dout]", i),
+			fmt.Sprintf("-codec:v:%d", i), p.VideoCodec,
+			fmt.Sprintf("-b:v:%d", i), fmt.Sprintf("%dk", r.VideoBitrate),
+			fmt.Sprintf("-maxrate:v:%d", i), fmt.Sprintf("%dk", r.VideoBitrate*107/100),
+			fmt.Sprintf("-bufsize:v:%d", i), fmt.Sprintf("%dk", r.VideoBitrate*3/2),
+		)
+		stream := fmt.Sprintf("v:%d", i)
+		if audio {
+			args = append(args, "-map", "0:a:0")
+			stream += fmt.Sprintf(",a:%d", i)
+		}
+		streams = append(streams, stream)
+	}
+	if p.Preset != "" {
+		args = append(args, "-preset", p.Preset)
+	}
+	args = append(args,
+		"-pix_fmt", "yuv420p",
+		"-sc_threshold", "0", // keyframes only on the GOP boundaries, so the renditions line up
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", hlsSegmentTime),
+	)
+	if audio {
+		args = append(args, "-codec:a", "aac", "-strict", "-2")
+		if p.AudioBitrate != "" {
+			args = append(args, "-b:a", p.AudioBitrate)
+		}
+		channels := p.AudioChannels
+		if channels == 0 {
+			channels = 2
+		}
+		args = append(args, "-ac", strconv.Itoa(channels))
+	}
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(hlsSegmentTime),
+		"-hls_playlist_type", "vod",
+		"-hls_flags", "independent_segments",
+		"-hls_segment_filename", filepath.Join(dir, "stream%v_%04d.ts"),
+		"-master_pl_name", HLSPlaylist,
+		"-var_stream_map", strings.Join(streams, " "),
+		filepath.Join(dir, "stream%v.m3u8"),
+	)
+	return args, nil
+}
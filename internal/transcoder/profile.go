@@ -0,0 +1,313 @@
+package transcoder
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/viewscreen/viewscreen/internal/probe"
+)
+
+// DefaultProfile is the ID of the profile whose output keeps the plain ".mp4" name.
+const DefaultProfile = "default"
+
+// Codecs a profile can encode to; "none" drops the stream.
+var (
+	VideoCodecs = []string{"libx264", "libx265", "none"}
+	AudioCodecs = []string{"aac", "none"}
+	Presets     = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"}
+)
+
+var bitrateRe = regexp.MustCompile(`^[0-9]+[kKmM]?$`)
+
+// Profile is a named set of encoding settings.
+type Profile struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+
+	VideoCodec   string `json:"video_codec"`             // "libx264", "libx265" or "none"
+	CRF          int    `json:"crf,omitempty"`           // quality, lower is better; 0 for the encoder's default
+	VideoBitrate string `json:"video_bitrate,omitempty"` // like "2M", instead of the CRF
+	Preset       string `json:"preset,omitempty"`        // like "fast" or "slow"; "" for the encoder's default
+	MaxHeight    int    `json:"max_height,omitempty"`    // scale taller videos down; 0 keeps the size
+
+	AudioCodec    string `json:"audio_codec"`              // "aac" or "none"
+	AudioBitrate  string `json:"audio_bitrate,omitempty"`  // like "192k"
+	AudioChannels int    `json:"audio_channels,omitempty"` // mix down to this many; 0 keeps them
+
+	// Copy streams browsers can already play instead of encoding them again, if they fit the limits.
+	Copy bool `json:"copy,omitempty"`
+
+	// Package as HLS in several renditions instead of one file; the source is kept.
+	// The renditions set the size and video bitrate.
+	HLS bool `json:"hls,omitempty"`
+}
+
+// BuiltinProfiles are always available; the first is the default.
+var BuiltinProfiles = []Profile{
+	{
+		Name:         "Default",
+		Description:  "Plays in browsers; streams that already do are copied",
+		VideoCodec:   "libx264",
+		CRF:          25,
+		AudioCodec:   "aac",
+		AudioBitrate: "384k",
+		Copy:         true,
+	},
+	{
+		Name:          "Phone 720p",
+		Description:   "Small files for phones and slow connections",
+		VideoCodec:    "libx264",
+		CRF:           26,
+		Preset:        "fast",
+		MaxHeight:     720,
+		AudioCodec:    "aac",
+		AudioBitrate:  "128k",
+		AudioChannels: 2,
+	},
+	{
+		Name:         "Archive quality",
+		Description:  "Close to the original, for keeping",
+		VideoCodec:   "libx264",
+		CRF:          18,
+		Preset:       "slow",
+		AudioCodec:   "aac",
+		AudioBitrate: "256k",
+		Copy:         true,
+	},
+	{
+		Name:         "Audio only",
+		Description:  "Just the sound, as an m4a",
+		VideoCodec:   "none",
+		AudioCodec:   "aac",
+		AudioBitrate: "192k",
+		Copy:         true,
+	},
+	{
+		Name:          "HLS",
+		Description:   "Adaptive streaming in up to 1080p, 720p and 480p, for slow or unsteady connections",
+		VideoCodec:    "libx264",
+		Preset:        "veryfast",
+		AudioCodec:    "aac",
+		AudioBitrate:  "128k",
+		AudioChannels: 2,
+		HLS:           true,
+	},
+}
+
+// ID returns the name in lower case with dashes, like "phone-720p". It's used in output filenames.
+func (p Profile) ID() string {
+	var id []rune
+	dash := false
+	for _, r := range strings.ToLower(p.Name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && len(id) > 0 {
+				id = append(id, '-')
+			}
+			id = append(id, r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return string(id)
+}
+
+// Builtin returns true if the profile is one of the built-in ones.
+func (p Profile) Builtin() bool {
+	for _, builtin := range BuiltinProfiles {
+		if builtin.ID() == p.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+// AudioOnly returns true if the profile drops the video.
+func (p Profile) AudioOnly() bool {
+	return p.VideoCodec == "none"
+}
+
+// Ext returns the extension of the output, with the dot.
+func (p Profile) Ext() string {
+	if p.AudioOnly() {
+		return ".m4a"
+	}
+	return ".mp4"
+}
+
+// Shrinks returns true if the output is expected to be much smaller than the source.
+func (p Profile) Shrinks() bool {
+	return p.HLS || p.AudioOnly() || p.AudioCodec == "none" || p.MaxHeight > 0 || p.VideoBitrate != ""
+}
+
+// Validate returns an error if ffmpeg can't use the profile.
+func (p Profile) Validate() error {
+	if p.ID() == "" {
+		return fmt.Errorf("missing profile name")
+	}
+	if !contains(VideoCodecs, p.VideoCodec) {
+		return fmt.Errorf("unknown video codec %q", p.VideoCodec)
+	}
+	if !contains(AudioCodecs, p.AudioCodec) {
+		return fmt.Errorf("unknown audio codec %q", p.AudioCodec)
+	}
+	if p.VideoCodec == "none" && p.AudioCodec == "none" {
+		return fmt.Errorf("a profile needs video or audio")
+	}
+	if p.HLS && p.VideoCodec != "libx264" {
+		return fmt.Errorf("HLS needs the libx264 video codec")
+	}
+	if p.CRF < 0 || p.CRF > 51 {
+		return fmt.Errorf("CRF must be between 0 and 51")
+	}
+	if p.Preset != "" && !contains(Presets, p.Preset) {
+		return fmt.Errorf("unknown preset %q", p.Preset)
+	}
+	for _, rate := range []string{p.VideoBitrate, p.AudioBitrate} {
+		if rate != "" && !bitrateRe.MatchString(rate) {
+			return fmt.Errorf("invalid bitrate %q (use a number like 2M or 192k)", rate)
+		}
+	}
+	if p.MaxHeight < 0 || p.AudioChannels < 0 {
+		return fmt.Errorf("height and channels can't be negative")
+	}
+	return nil
+}
+
+// String describes the settings, like "libx264 CRF 26 fast, 720p, aac 128k stereo".
+func (p Profile) String() string {
+	var video, audio []string
+	if p.HLS {
+		var heights []string
+		for _, r := range HLSRenditions {
+			heights = append(heights, fmt.Sprintf("%dp", r.Height))
+		}
+		video = append(video, "HLS", strings.Join(heights, "/"))
+		if p.Preset != "" {
+			video = append(video, p.Preset)
+		}
+	} else if p.VideoCodec != "none" {
+		video = append(video, p.VideoCodec)
+		if p.VideoBitrate != "" {
+			video = append(video, p.VideoBitrate)
+		} else if p.CRF > 0 {
+			video = append(video, "CRF "+strconv.Itoa(p.CRF))
+		}
+		if p.Preset != "" {
+			video = append(video, p.Preset)
+		}
+		if p.MaxHeight > 0 {
+			video = append(video, fmt.Sprintf("%dp", p.MaxHeight))
+		}
+	}
+	if p.AudioCodec != "none" {
+		audio = append(audio, p.AudioCodec)
+		if p.AudioBitrate != "" {
+			audio = append(audio, p.AudioBitrate)
+		}
+		switch p.AudioChannels {
+		case 0:
+		case 1:
+			audio = append(audio, "mono")
+		case 2:
+			audio = append(audio, "stereo")
+		default:
+			audio = append(audio, strconv.Itoa(p.AudioChannels)+" channels")
+		}
+	}
+	var parts []string
+	for _, part := range [][]string{video, audio} {
+		if len(part) > 0 {
+			parts = append(parts, strings.Join(part, " "))
+		}
+	}
+	if p.Copy {
+		parts = append(parts, "copies playable streams")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// args returns the ffmpeg arguments for the streams and the mode they add up to.
+// Without media info, every stream is encoded.
+func (p Profile) args(info *probe.Info) ([]string, string) {
+	copyVideo, copyAudio := false, false
+	if p.Copy && info != nil {
+		v := info.Video()
+		copyVideo = p.VideoCodec == "libx264" && info.VideoStreamable() &&
+			(p.MaxHeight == 0 || v == nil || v.Height <= p.MaxHeight)
+		copyAudio = p.AudioCodec == "aac" && info.AudioStreamable() &&
+			(p.AudioChannels == 0 || info.MaxChannels() <= p.AudioChannels)
+	}
+
+	var args []string
+	switch {
+	case p.VideoCodec == "none":
+		args = append(args, "-vn")
+	case copyVideo:
+		args = append(args, "-codec:v", "copy")
+	default:
+		args = append(args, "-codec:v", p.VideoCodec)
+		if p.VideoBitrate != "" {
+			args = append(args, "-b:v", p.VideoBitrate)
+		} else if p.CRF > 0 {
+			args = append(args, "-crf", strconv.Itoa(p.CRF))
+		}
+		if p.Preset != "" {
+			args = append(args, "-preset", p.Preset)
+		}
+		if p.MaxHeight > 0 {
+			// Keep the aspect ratio with an even width, and never scale up.
+			args = append(args, "-vf", fmt.Sprintf("scale=-2:min(ih\\,%d)", p.MaxHeight))
+		}
+		if p.VideoCodec == "libx265" {
+			args = append(args, "-tag:v", "hvc1") // what Apple devices expect
+		}
+		args = append(args,
+			"-bf", "2",
+			"-flags", "+cgop",
+			"-pix_fmt", "yuv420p",
+		)
+	}
+	switch {
+	case p.AudioCodec == "none":
+		args = append(args, "-an")
+	case copyAudio:
+		args = append(args, "-codec:a", "copy")
+	default:
+		args = append(args,
+			"-codec:a", p.AudioCodec,
+			"-strict", "-2",
+		)
+		if p.AudioBitrate != "" {
+			args = append(args, "-b:a", p.AudioBitrate)
+		}
+		if p.AudioChannels > 0 {
+			args = append(args, "-ac", strconv.Itoa(p.AudioChannels))
+		}
+		args = append(args, "-r:a", "48000")
+	}
+
+	encodeVideo := p.VideoCodec != "none" && !copyVideo
+	encodeAudio := p.AudioCodec != "none" && !copyAudio
+	mode := ModeTranscode
+	switch {
+	case !encodeVideo && !encodeAudio:
+		mode = ModeRemux
+	case !encodeVideo:
+		mode = ModeAudio
+	case !encodeAudio:
+		mode = ModeVideo
+	}
+	return args, mode
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
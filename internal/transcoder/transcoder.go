@@ -1,29 +1,124 @@
 package transcoder
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/viewscreen/viewscreen/internal/probe"
+
 	log "github.com/Sirupsen/logrus"
 )
 
+// A transcode may be this much shorter than its source, for dropped trailing frames.
+var maxDurationLoss = 5 * time.Second
+
+// Only the end of ffmpeg's output is kept for failed jobs.
+var outputLimit = 8 * 1024
+
+// Transcode modes, from cheapest to most expensive.
+const (
+	ModeRemux     = "remux"     // copy the streams into an mp4
+	ModeAudio     = "audio"     // encode only the audio
+	ModeVideo     = "video"     // encode only the video
+	ModeTranscode = "transcode" // encode everything
+	ModeHLS       = "hls"       // encode every HLS rendition
+)
+
+// Job is a file to transcode with a profile.
+type Job struct {
+	Src     string
+	Profile Profile
+
+	// Keep the source file after transcoding.
+	Keep bool
+
+	// Mode is set when the job starts, from the codecs of the source.
+	Mode string
+
+	cmd      *exec.Cmd
+	progress Progress
+	canceled bool
+	output   string
+}
+
+// match returns true if the job is for the source file and profile ID, or any profile if it's "".
+func (job *Job) match(srcname, profile string) bool {
+	return job.Src == srcname && (profile == "" || job.Profile.ID() == profile)
+}
+
+// Status is a queued or running job.
+type Status struct {
+	Src      string
+	Profile  string // ID
+	State    string // "queued" or "running"
+	Mode     string
+	Progress Progress
+}
+
+// Progress is how far along a running job is.
+type Progress struct {
+	Done     time.Duration // of the output written so far
+	Duration time.Duration // of the source, or 0 if it isn't known
+	Speed    float64       // times real time
+}
+
+// Percent returns how much of the source is done, or 0 if its duration isn't known.
+func (p Progress) Percent() float64 {
+	if p.Duration <= 0 {
+		return 0
+	}
+	percent := float64(p.Done) / float64(p.Duration) * 100
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// ETA returns the time left at the current speed, or 0 if it isn't known.
+func (p Progress) ETA() time.Duration {
+	if p.Duration <= 0 || p.Speed <= 0 || p.Done >= p.Duration {
+		return 0
+	}
+	return time.Duration(float64(p.Duration-p.Done) / p.Speed)
+}
+
+// Result is a finished job.
+type Result struct {
+	Src      string
+	Dst      string // the new file, or "" if the job failed
+	Profile  string // ID
+	Mode     string
+	Started  time.Time
+	Finished time.Time
+	Canceled bool
+	Err      error
+	Output   string // the end of ffmpeg's output, if it failed
+}
+
 type Transcoder struct {
 	sync.RWMutex
 	concurrency int
-	queue       []string
-	running     map[string]*exec.Cmd
+	queue       []*Job
+	running     map[string]*Job // by output filename
+
+	// Finished is called with the result of each job (optional).
+	Finished func(res Result)
 }
 
 func NewTranscoder() *Transcoder {
 	t := &Transcoder{}
-	t.running = make(map[string]*exec.Cmd)
+	t.running = make(map[string]*Job)
 	t.concurrency = runtime.NumCPU()
 	go t.manager()
 	return t
@@ -33,70 +128,106 @@ func (t *Transcoder) manager() {
 	for {
 		t.Lock()
 		if len(t.queue) > 0 && len(t.running) < t.concurrency {
-			srcname := t.queue[0]
+			job := t.queue[0]
 			t.queue = t.queue[1:]
-			log.Debugf("job manager adding %q", srcname)
-			go t.transcode(srcname)
+			log.Debugf("job manager adding %q", job.Src)
+			go t.transcode(job)
 		}
 		t.Unlock()
 		time.Sleep(5 * time.Second)
 	}
 }
 
-func (t *Transcoder) queued(srcname string) bool {
+// jobs returns the queued and running jobs for the source file and profile ID, or any profile if it's "".
+// Running jobs are sorted by output filename.
+func (t *Transcoder) jobs(srcname, profile string) (queued, running []*Job) {
 	for _, job := range t.queue {
-		if job == srcname {
-			return true
+		if job.match(srcname, profile) {
+			queued = append(queued, job)
 		}
 	}
-	return false
+	var dstnames []string
+	for dstname := range t.running {
+		dstnames = append(dstnames, dstname)
+	}
+	sort.Strings(dstnames)
+	for _, dstname := range dstnames {
+		if job := t.running[dstname]; job.match(srcname, profile) {
+			running = append(running, job)
+		}
+	}
+	return queued, running
 }
 
-func (t *Transcoder) dequeue(srcname string) {
-	var keep []string
-	for _, job := range t.queue {
-		if job == srcname {
+func (t *Transcoder) dequeue(job *Job) {
+	var keep []*Job
+	for _, queued := range t.queue {
+		if queued == job {
 			continue
 		}
-		keep = append(keep, job)
+		keep = append(keep, queued)
 	}
 	t.queue = keep
 }
 
-func (t *Transcoder) Cancel(srcname string) error {
+// Cancel removes the jobs for the source file and profile ID, or every profile if it's "".
+func (t *Transcoder) Cancel(srcname, profile string) error {
 	t.Lock()
 	defer t.Unlock()
 
-	if t.queued(srcname) {
-		log.Infof("dequeing %q", srcname)
-		t.dequeue(srcname)
-		return nil
-	}
-
-	// must be an active job now or it doesn't exist.
-	cmd, ok := t.running[srcname]
-	if !ok {
+	queued, running := t.jobs(srcname, profile)
+	if len(queued) == 0 && len(running) == 0 {
 		return fmt.Errorf("no transcoding job found")
 	}
-	// it's actually running, so kill it.
-	if cmd.Process != nil {
-		log.Infof("killing transcode job %q", srcname)
-		if err := cmd.Process.Kill(); err != nil {
-			return err
+	for _, job := range queued {
+		log.Infof("dequeing %q (%s)", srcname, job.Profile.ID())
+		t.dequeue(job)
+	}
+
+	// these are actually running, so kill them.
+	for _, job := range running {
+		job.canceled = true
+		if cmd := job.cmd; cmd.Process != nil {
+			log.Infof("killing transcode job %q (%s)", srcname, job.Profile.ID())
+			if err := cmd.Process.Kill(); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
-func (t *Transcoder) filenames(srcname string) (string, string, string) {
+// Output returns the filename the source file is transcoded to with the profile.
+func Output(srcname string, profile Profile) string {
+	_, _, dstname := filenames(srcname, profile)
+	return dstname
+}
+
+func filenames(srcname string, profile Profile) (string, string, string) {
 	srcname = filepath.Clean(srcname)
 	dir := filepath.Dir(srcname)           // "/some dir"
 	ext := filepath.Ext(srcname)           // ".avi"
 	base := filepath.Base(srcname)         // "somewhere.avi"
 	noext := strings.TrimSuffix(base, ext) // "somewhere"
 
-	tmpname := fmt.Sprintf("%s/.%s.mp4", dir, noext)
-	dstname := fmt.Sprintf("%s/%s.mp4", dir, noext)
+	// HLS packages are directories beside the source.
+	if profile.HLS {
+		hlsdir := HLSDir(srcname) // "/some dir/.hls/somewhere.avi"
+		tmpname := filepath.Join(filepath.Dir(hlsdir), "."+base+".tmp")
+		return srcname, tmpname, filepath.Join(hlsdir, HLSPlaylist)
+	}
+
+	// Other profiles add their ID, so the versions can exist side by side.
+	name := noext + profile.Ext() // "somewhere.mp4"
+	if id := profile.ID(); id != DefaultProfile {
+		name = noext + "." + id + profile.Ext() // "somewhere.phone-720p.mp4"
+	} else if strings.EqualFold(ext, ".mp4") {
+		// An mp4 with codecs browsers can't play must not be overwritten by its own conversion.
+		name = noext + ".h264.mp4"
+	}
+
+	tmpname := fmt.Sprintf("%s/.%s", dir, name)
+	dstname := fmt.Sprintf("%s/%s", dir, name)
 	return srcname, tmpname, dstname
 }
 
@@ -118,27 +249,72 @@ func (t *Transcoder) RunningCount() int {
 	return len(t.running)
 }
 
+// Jobs returns the running jobs, sorted by output filename, followed by the queued jobs in order.
+func (t *Transcoder) Jobs() []Status {
+	t.RLock()
+	defer t.RUnlock()
+
+	var jobs []Status
+	var dstnames []string
+	for dstname := range t.running {
+		dstnames = append(dstnames, dstname)
+	}
+	sort.Strings(dstnames)
+	for _, dstname := range dstnames {
+		job := t.running[dstname]
+		jobs = append(jobs, Status{Src: job.Src, Profile: job.Profile.ID(), State: "running", Mode: job.Mode, Progress: job.progress})
+	}
+	for _, job := range t.queue {
+		jobs = append(jobs, Status{Src: job.Src, Profile: job.Profile.ID(), State: "queued"})
+	}
+	return jobs
+}
+
+// Active returns true if any job for the source file is queued or running.
 func (t *Transcoder) Active(srcname string) bool {
 	t.RLock()
 	defer t.RUnlock()
 
+	queued, running := t.jobs(srcname, "")
+
 	// check if waiting in queued
-	if t.queued(srcname) {
+	if len(queued) > 0 {
 		return true
 	}
 
 	// check if it's actually running
-	cmd, ok := t.running[srcname]
-	if !ok {
-		return false
+	for _, job := range running {
+		if cmd := job.cmd; cmd.Process != nil && cmd.Process.Signal(syscall.Signal(0)) == nil {
+			return true
+		}
 	}
-	if cmd.Process == nil {
-		return false
+	return false
+}
+
+// Mode returns the mode of the first running job for the source file, or "" if none is running.
+func (t *Transcoder) Mode(srcname string) string {
+	t.RLock()
+	defer t.RUnlock()
+	if _, running := t.jobs(srcname, ""); len(running) > 0 {
+		return running[0].Mode
+	}
+	return ""
+}
+
+// Progress returns how far along the first running job for the source file is.
+// It returns false if none is running.
+func (t *Transcoder) Progress(srcname string) (Progress, bool) {
+	t.RLock()
+	defer t.RUnlock()
+	if _, running := t.jobs(srcname, ""); len(running) > 0 {
+		return running[0].progress, true
 	}
-	return cmd.Process.Signal(syscall.Signal(0)) == nil
+	return Progress{}, false
 }
 
-func (t *Transcoder) Add(srcname string) error {
+// Add queues the file to be transcoded with the profile, and removes it afterwards unless keep is set.
+// The source is only removed once every job for it has finished.
+func (t *Transcoder) Add(srcname string, profile Profile, keep bool) error {
 	fi, err := os.Stat(srcname)
 	if err != nil {
 		return err
@@ -146,124 +322,284 @@ func (t *Transcoder) Add(srcname string) error {
 	if fi.IsDir() {
 		return fmt.Errorf("must be a file (not a dir)")
 	}
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+	// HLS is streamed instead of the source, which stays for downloading.
+	if profile.HLS {
+		keep = true
+	}
 
-	// return if already queued.
-	t.RLock()
-	if t.queued(srcname) {
-		t.RUnlock()
+	t.Lock()
+	defer t.Unlock()
+
+	// return if already queued or running.
+	if queued, running := t.jobs(srcname, profile.ID()); len(queued) > 0 || len(running) > 0 {
 		return nil
 	}
-	t.RUnlock()
+	t.queue = append(t.queue, &Job{Src: srcname, Profile: profile, Keep: keep})
+	return nil
+}
+
+// SetKeep changes whether the source of the queued and running jobs for it is kept.
+// It returns false if there are no such jobs.
+func (t *Transcoder) SetKeep(srcname string, keep bool) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	queued, running := t.jobs(srcname, "")
+	for _, job := range append(queued, running...) {
+		job.Keep = keep
+	}
+	return len(queued) > 0 || len(running) > 0
+}
+
+func (t *Transcoder) transcode(job *Job) {
+	started := time.Now()
+	dstname, err := t.convert(job)
+	if err != nil {
+		log.Errorf("job %q: %s", job.Src, err)
+	}
+	if t.Finished == nil {
+		return
+	}
 
-	// return if already running.
 	t.RLock()
-	if _, ok := t.running[srcname]; ok {
-		t.RUnlock()
-		return nil
+	res := Result{
+		Src:      job.Src,
+		Dst:      dstname,
+		Profile:  job.Profile.ID(),
+		Mode:     job.Mode,
+		Started:  started,
+		Finished: time.Now(),
+		Canceled: job.canceled,
+		Err:      err,
+		Output:   job.output,
 	}
 	t.RUnlock()
-
-	t.Lock()
-	t.queue = append(t.queue, srcname)
-	t.Unlock()
-	return nil
+	t.Finished(res)
 }
 
-func (t *Transcoder) transcode(srcname string) {
-	srcname, tmpname, dstname := t.filenames(srcname)
+// convert transcodes the source file with the job's profile and returns the new filename.
+func (t *Transcoder) convert(job *Job) (string, error) {
+	srcname, tmpname, dstname := filenames(job.Src, job.Profile)
 
 	srcfi, err := os.Stat(srcname)
 	if err != nil {
-		log.Errorf("job %q: %s", srcname, err)
-		return
+		return "", err
 	}
 
 	// Find ffmpeg
 	ffmpeg, err := exec.LookPath("ffmpeg")
 	if err != nil {
-		log.Error(err)
-		return
+		return "", err
+	}
+
+	// The profile decides which streams can be copied; without media info, encode everything.
+	var duration time.Duration
+	info, err := probe.Cached(srcname)
+	if err != nil {
+		log.Warnf("job %q: %s", srcname, err)
+	} else {
+		duration = info.Duration()
 	}
 
-	cmd, err := exec.Command(ffmpeg,
+	args := []string{
 		"-y",
+		"-progress", "pipe:1", // report progress on stdout
+		"-nostats",
 		"-i", srcname,
-		"-codec:v", "libx264",
-		"-crf", "25",
-		"-bf", "2",
-		"-flags", "+cgop",
-		"-pix_fmt", "yuv420p",
-		"-codec:a", "aac",
-		"-strict", "-2",
-		"-b:a", "384k",
-		"-r:a", "48000",
-		"-movflags", "faststart", // make streaming work
-		"-max_muxing_queue_size", "500", // handle sparse audio/video frames (see: https://trac.ffmpeg.org/ticket/6375#comment:2)
-		tmpname,
-	), nil
+	}
+	mode := ModeHLS
+	if job.Profile.HLS {
+		if info == nil {
+			return "", fmt.Errorf("packaging as HLS needs media info")
+		}
+		hlsArgs, err := job.Profile.hlsArgs(info, tmpname)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, hlsArgs...)
+	} else {
+		var streamArgs []string
+		streamArgs, mode = job.Profile.args(info)
+		args = append(args, streamArgs...)
+		args = append(args,
+			"-movflags", "faststart", // make streaming work
+			"-max_muxing_queue_size", "500", // handle sparse audio/video frames (see: https://trac.ffmpeg.org/ticket/6375#comment:2)
+			tmpname,
+		)
+	}
+	cmd := exec.Command(ffmpeg, args...)
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		log.Errorf("ffmpeg failed: %s", err)
-		return
+		return "", err
 	}
+	output := &tailWriter{max: outputLimit}
+	cmd.Stderr = output
 
 	// Add as a running job.
-	log.Infof("adding transcode job %q -> %q", srcname, dstname)
+	log.Infof("adding transcode job %q -> %q (%s, %s)", srcname, dstname, job.Profile.ID(), mode)
+	started := time.Now()
 	t.Lock()
-	t.running[srcname] = cmd
+	job.cmd = cmd
+	job.Mode = mode
+	job.progress = Progress{Duration: duration}
+	t.running[dstname] = job
 	t.Unlock()
 
 	// Remove on completion.
 	defer func() {
 		t.Lock()
-		delete(t.running, srcname)
+		delete(t.running, dstname)
 		t.Unlock()
 
-		// Remove the temp file if it still exists at this point.
-		os.Remove(tmpname)
+		// Remove the temp file (or HLS directory) if it still exists at this point.
+		os.RemoveAll(tmpname)
 	}()
 
 	// Transcode
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Errorf("job %q: %s", srcname, string(output))
-		return
+	if job.Profile.HLS {
+		os.RemoveAll(tmpname)
+		if err := os.MkdirAll(tmpname, 0755); err != nil {
+			return "", err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("ffmpeg failed: %s", err)
+	}
+	t.readProgress(job, stdout)
+	if err := cmd.Wait(); err != nil {
+		t.Lock()
+		job.output = output.String()
+		t.Unlock()
+		return "", fmt.Errorf("ffmpeg failed: %s", err)
+	}
+
+	// Replace the old HLS package, if any; the source stays.
+	if job.Profile.HLS {
+		hlsdir := filepath.Dir(dstname)
+		os.RemoveAll(hlsdir)
+		if err := os.Rename(tmpname, hlsdir); err != nil {
+			return "", err
+		}
+		log.Infof("job %q: %s (%s) finished in %s", srcname, mode, job.Profile.ID(), time.Since(started).Round(time.Second))
+		return dstname, nil
 	}
 
 	// Rename temp file to real file.
 	if err := os.Rename(tmpname, dstname); err != nil {
-		log.Errorf("job %q: %s", srcname, err)
-		return
+		return "", err
 	}
 
-	// check that our new file is a reasonable size.
-	// TODO: ffprobe and check duration matches?
+	// check that our new file is a reasonable size, unless the profile makes it much smaller.
 	minsize := srcfi.Size() / 5
 	dstfi, err := os.Stat(dstname)
 	if err != nil {
-		log.Errorf("job %q: %s", srcname, err)
-		return
+		return "", err
 	}
-	if dstfi.Size() < minsize {
-		log.Errorf("job %q: transcoded is too small (%d vs %d); deleting.", srcname, dstfi.Size(), minsize)
+	if dstfi.Size() < minsize && !job.Profile.Shrinks() {
 		if err := os.Remove(dstname); err != nil {
 			log.Error(err)
 		}
-		return
+		return "", fmt.Errorf("transcoded is too small (%d vs %d); deleting.", dstfi.Size(), minsize)
+	}
+
+	// check that nothing was cut off, if ffprobe can tell.
+	if srcinfo, err := probe.Cached(srcname); err == nil {
+		dstinfo, err := probe.Cached(dstname)
+		if err != nil {
+			log.Warnf("job %q: probing %q failed: %s", srcname, dstname, err)
+		} else if missing := srcinfo.Duration() - dstinfo.Duration(); missing > maxDurationLoss {
+			os.Remove(probe.Cachefile(dstname))
+			if err := os.Remove(dstname); err != nil {
+				log.Error(err)
+			}
+			return "", fmt.Errorf("transcoded is too short (%s vs %s); deleting.", dstinfo.Duration(), srcinfo.Duration())
+		}
+	}
+
+	log.Infof("job %q: %s (%s) finished in %s", srcname, mode, job.Profile.ID(), time.Since(started).Round(time.Second))
+
+	// Remove the source file, unless it's still needed by this or another job.
+	// A job that keeps it passes that on, so the last job only removes it if none wanted it kept.
+	t.Lock()
+	keep := job.Keep
+	queued, running := t.jobs(srcname, "")
+	others := 0
+	for _, other := range append(queued, running...) {
+		if other == job {
+			continue
+		}
+		other.Keep = other.Keep || keep
+		others++
 	}
+	t.Unlock()
 
-	// Rename the old thumbnail if it exists.
+	// Move the old thumbnail if the source goes, or share it if it stays.
 	oldthumb := srcname + ".thumbnail.png"
 	newthumb := dstname + ".thumbnail.png"
+	if keep || others > 0 {
+		os.Link(oldthumb, newthumb)
+		return dstname, nil
+	}
 	if _, err := os.Stat(oldthumb); err == nil {
 		if err := os.Rename(oldthumb, newthumb); err != nil {
-			log.Errorf("job %q: %s", srcname, err)
-			return
+			return "", err
 		}
 	}
-
-	// Remove the source file.
+	// Same for an HLS package of the source.
+	if _, err := os.Stat(HLSDir(srcname)); err == nil {
+		if err := os.Rename(HLSDir(srcname), HLSDir(dstname)); err != nil {
+			log.Warnf("job %q: moving the HLS package failed: %s", srcname, err)
+		}
+	}
+	os.Remove(probe.Cachefile(srcname))
 	if err := os.Remove(srcname); err != nil {
-		log.Errorf("job %q: %s", srcname, err)
-		return
+		return "", err
+	}
+	return dstname, nil
+}
+
+// readProgress updates the job from ffmpeg's progress reports, until ffmpeg exits.
+func (t *Transcoder) readProgress(job *Job, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		key, value := fields[0], strings.TrimSpace(fields[1])
+
+		t.Lock()
+		switch key {
+		case "out_time_us", "out_time_ms": // both are in microseconds
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+				job.progress.Done = time.Duration(n) * time.Microsecond
+			}
+		case "speed":
+			if speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+				job.progress.Speed = speed
+			}
+		}
+		t.Unlock()
 	}
 }
+
+// tailWriter keeps the last bytes written to it.
+type tailWriter struct {
+	max int
+	buf []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.max {
+		w.buf = w.buf[len(w.buf)-w.max:]
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	return string(w.buf)
+}
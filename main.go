@@ -55,6 +55,9 @@ var (
 	// torrent
 	torrentListenAddr string
 
+	// usually ".blackhole" in the download dir.
+	watchDir string
+
 	// reverse proxy authentication
 	reverseProxyAuthIP     string
 	reverseProxyAuthHeader string
@@ -87,6 +90,21 @@ var (
 
 	// config
 	config *Config
+
+	// feed subscriptions
+	subscriptions *Subscriptions
+
+	// API tokens
+	tokens *Tokens
+
+	// user accounts
+	users *Users
+
+	// login sessions
+	sessions *Sessions
+
+	// finished transcodes
+	transcodeHistory *TranscodeHistory
 )
 
 func NewLogtailer(size int64) (*logtailer, error) {
@@ -133,8 +151,9 @@ func init() {
 	cli.StringVar(&httpAddr, "http-addr", ":80", "listen address")
 	cli.StringVar(&httpHost, "http-host", "", "HTTP host")
 	cli.StringVar(&httpPrefix, "http-prefix", "/viewscreen", "HTTP URL prefix (not supported yet)")
-	cli.StringVar(&httpUsername, "http-username", "viewscreen", "HTTP basic auth username")
+	cli.StringVar(&httpUsername, "http-username", "viewscreen", "login username")
 	cli.StringVar(&torrentListenAddr, "torrent-addr", ":61337", "listen address for torrent client")
+	cli.StringVar(&watchDir, "watch-dir", "", "watch directory for .torrent and .magnet files (default: <download-dir>/.blackhole)")
 	cli.StringVar(&reverseProxyAuthIP, "reverse-proxy-ip", "", "reverse proxy auth IP")
 	cli.StringVar(&reverseProxyAuthHeader, "reverse-proxy-header", "X-Authenticated-User", "reverse proxy auth header")
 	cli.BoolVar(&showVersion, "version", false, "display version and exit")
@@ -177,7 +196,7 @@ func library(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		Redirect(w, r, "/help")
 		return
 	}
-	if sortby != "name" {
+	if sortby != "name" && sortby != "duration" {
 		sortby = "time"
 	}
 
@@ -202,9 +221,12 @@ func library(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		dls = rawdls
 	}
 
-	// sort by recent
-	if sortby == "time" {
+	// sort by recent, or longest first
+	switch sortby {
+	case "time":
 		sort.Slice(dls, func(i, j int) bool { return dls[i].Created.After(dls[j].Created) })
+	case "duration":
+		sortDuration(dls)
 	}
 
 	res.Query = query
@@ -214,6 +236,15 @@ func library(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	HTML(w, "index.html", res)
 }
 
+// sortDuration sorts the downloads longest first.
+func sortDuration(dls []Download) {
+	durations := make(map[string]time.Duration)
+	for _, dl := range dls {
+		durations[dl.ID] = dl.Duration()
+	}
+	sort.SliceStable(dls, func(i, j int) bool { return durations[dls[i].ID] > durations[dls[j].ID] })
+}
+
 func dlList(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	dls, err := ListDownloads()
 	if err != nil {
@@ -251,6 +282,10 @@ func dlView(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		return
 	}
 
+	if err := dl.Watch(); err != nil {
+		logger.Warnf("recording %q as watched failed: %s", dl.ID, err)
+	}
+
 	res := NewResponse(r, ps)
 	res.Download = dl
 	res.File = file
@@ -291,19 +326,101 @@ func dlStream(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	http.ServeFile(w, r, file.Path)
 }
 
+// dlHLS serves the playlists and segments of a file packaged as HLS.
+// The path is the file ID followed by a name in the package, like "movie.mkv/master.m3u8".
+func dlHLS(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	dl, err := FindDownload(ps.ByName("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	path := strings.TrimPrefix(ps.ByName("file"), "/")
+	for _, file := range dl.Files(false) {
+		if !strings.HasPrefix(path, file.ID+"/") {
+			continue
+		}
+		name := filepath.Clean("/" + strings.TrimPrefix(path, file.ID+"/"))
+		switch filepath.Ext(name) {
+		case ".m3u8":
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Header().Set("Cache-Control", "no-cache")
+		case ".ts":
+			w.Header().Set("Content-Type", "video/mp2t")
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", 7*86400))
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(transcoder.HLSDir(file.Path), name))
+		return
+	}
+	http.NotFound(w, r)
+}
+
 func dlRemove(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	dl, err := FindDownload(ps.ByName("id"))
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
-	if err := os.RemoveAll(dl.Path()); err != nil {
+	if _, err := TrashDownload(dl, ps.ByName("user")); err != nil {
 		Error(w, err)
 		return
 	}
 	Redirect(w, r, "/?message=downloadremoved")
 }
 
+//
+// Trash
+//
+
+func trash(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	items, err := ListTrash()
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	res := NewResponse(r, ps)
+	res.Section = "trash"
+	res.Trash = items
+	HTML(w, "trash.html", res)
+}
+
+func trashRestore(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	dl, err := RestoreTrash(ps.ByName("id"), ps.ByName("user"))
+	if err == ErrTrashNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/downloads/files/%s?message=downloadrestored", dl.ID)
+}
+
+func trashPurge(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	err := PurgeTrash(ps.ByName("id"), ps.ByName("user"))
+	if err == ErrTrashNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/trash?message=trashpurged")
+}
+
+func trashEmpty(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := EmptyTrash(ps.ByName("user")); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/trash?message=trashpurged")
+}
+
 func dlShare(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	id := ps.ByName("id")
 	dl, err := FindDownload(id)
@@ -344,7 +461,16 @@ func transferList(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 }
 
 func transferMagnet(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if err := StartTransfer(r.FormValue("target")); err != nil {
+	if f, _, err := r.FormFile("torrent"); err == nil {
+		defer f.Close()
+		if err := StartTorrentTransfer(f, ps.ByName("user")); err != nil {
+			Error(w, err)
+			return
+		}
+		JSON(w, `{ status: "success" }`)
+		return
+	}
+	if err := StartTransfer(r.FormValue("target"), ps.ByName("user")); err != nil {
 		Error(w, err)
 		return
 	}
@@ -352,11 +478,22 @@ func transferMagnet(w http.ResponseWriter, r *http.Request, ps httprouter.Params
 }
 
 func transferStart(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Uploaded .torrent file
+	if f, _, err := r.FormFile("torrent"); err == nil {
+		defer f.Close()
+		if err := StartTorrentTransfer(f, ps.ByName("user")); err != nil {
+			Error(w, err)
+			return
+		}
+		Redirect(w, r, "/import")
+		return
+	}
+
 	target := strings.TrimSpace(r.FormValue("target"))
 	if target == "" {
 		target = ps.ByName("target")
 	}
-	if err := StartTransfer(target); err != nil {
+	if err := StartTransfer(target, ps.ByName("user")); err != nil {
 		Error(w, err)
 		return
 	}
@@ -364,17 +501,229 @@ func transferStart(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 }
 
 func transferCancel(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if err := CancelTransfer(ps.ByName("id")); err != nil {
+	if err := CancelTransfer(ps.ByName("id"), ps.ByName("user")); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/import")
+}
+
+func transferPause(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := PauseTransfer(ps.ByName("id")); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/import")
+}
+
+func transferResume(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := ResumeTransfer(ps.ByName("id")); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/import")
+}
+
+func transferTop(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := MoveTransferTop(ps.ByName("id")); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/import")
+}
+
+func transferBottom(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := MoveTransferBottom(ps.ByName("id")); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/import")
+}
+
+func transferPriority(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	priority, err := strconv.Atoi(strings.TrimSpace(r.FormValue("priority")))
+	if err != nil {
+		Error(w, fmt.Errorf("invalid priority %q", r.FormValue("priority")))
+		return
+	}
+	if err := SetTransferPriority(ps.ByName("id"), priority); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/import")
+}
+
+func transferFiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	t, err := FindTransfer(ps.ByName("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method == "GET" {
+		files, err := ListTransferFiles(t.ID)
+		if err != nil {
+			Error(w, err)
+			return
+		}
+		res := NewResponse(r, ps)
+		res.Transfer = t
+		res.TransferFiles = files
+		res.Section = "import"
+		HTML(w, "transfers/files.html", res)
+		return
+	}
+
+	priorities, err := parseFilePriorities(r)
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	if err := SetTransferFiles(t.ID, priorities); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/import")
+}
+
+func transferView(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	t, err := FindTransfer(ps.ByName("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	index, err := strconv.Atoi(ps.ByName("index"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	files, err := ListTransferFiles(t.ID)
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	if index < 0 || index >= len(files) {
+		http.NotFound(w, r)
+		return
+	}
+
+	res := NewResponse(r, ps)
+	res.Transfer = t
+	res.TransferFile = files[index]
+	res.Section = "view"
+	HTML(w, "transfers/view.html", res)
+}
+
+func transferStream(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	index, err := strconv.Atoi(ps.ByName("index"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	// The reader blocks until the requested pieces are downloaded.
+	reader, err := StreamTransferFile(r.Context(), ps.ByName("id"), index)
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	defer reader.Close()
+
+	files, err := ListTransferFiles(ps.ByName("id"))
+	if err != nil || index >= len(files) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, filepath.Base(files[index].Path), time.Time{}, reader)
+}
+
+func transferSeed(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	t, err := FindTransfer(ps.ByName("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method == "GET" {
+		res := NewResponse(r, ps)
+		res.Transfer = t
+		res.Section = "import"
+		HTML(w, "transfers/seed.html", res)
+		return
+	}
+
+	if err := SetTransferSeedRules(t.ID, parseSeedRules(r, t.Seed)); err != nil {
 		Error(w, err)
 		return
 	}
 	Redirect(w, r, "/import")
 }
 
+// parseSeedRules reads the seed ratio and times in minutes, keeping the defaults for missing values.
+func parseSeedRules(r *http.Request, rules downloader.SeedRules) downloader.SeedRules {
+	if n, err := strconv.ParseFloat(strings.TrimSpace(r.FormValue("ratio")), 64); err == nil {
+		rules.Ratio = n
+	}
+	minutes := func(key string, d *time.Duration) {
+		if n, err := strconv.Atoi(strings.TrimSpace(r.FormValue(key))); err == nil && n >= 0 {
+			*d = time.Duration(n) * time.Minute
+		}
+	}
+	minutes("seedmintime", &rules.MinTime)
+	minutes("seedmaxtime", &rules.MaxTime)
+	minutes("seedidletime", &rules.IdleTime)
+	return rules
+}
+
+// parseFilePriorities reads "file-<index>=<priority>" form values.
+func parseFilePriorities(r *http.Request) (map[int]int, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	priorities := make(map[int]int)
+	for key, values := range r.Form {
+		if !strings.HasPrefix(key, "file-") || len(values) == 0 {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(key, "file-"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid file %q", key)
+		}
+		prio, err := strconv.Atoi(values[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority %q", values[0])
+		}
+		priorities[index] = prio
+	}
+	return priorities, nil
+}
+
 //
 // Transcoding
 //
 
+func transcodes(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	res := NewResponse(r, ps)
+	res.Section = "transcodes"
+	res.TranscodeJobs = ListTranscodeJobs()
+	res.TranscodeHistory = ListTranscodeHistory()
+	HTML(w, "transcodes.html", res)
+}
+
+func transcodeList(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	res := NewResponse(r, ps)
+	res.TranscodeJobs = ListTranscodeJobs()
+	HTML(w, "transcodes/list.html", res)
+}
+
+func transcodesClear(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := transcodeHistory.Clear(); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/transcodes?message=transcodescleared")
+}
+
 func transcodeStart(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	dl, err := FindDownload(ps.ByName("id"))
 	if err != nil {
@@ -389,7 +738,7 @@ func transcodeStart(w http.ResponseWriter, r *http.Request, ps httprouter.Params
 	}
 
 	logger.Debugf("starting trancode %q", file.Path)
-	if err := StartTranscode(file.Path); err != nil {
+	if err := StartTranscode(file.Path, r.FormValue("profile")); err != nil {
 		Error(w, err)
 		return
 	}
@@ -411,10 +760,14 @@ func transcodeCancel(w http.ResponseWriter, r *http.Request, ps httprouter.Param
 
 	logger.Debugf("canceling trancode %q", file.Path)
 
-	if err := CancelTranscode(file.Path); err != nil {
+	if err := CancelTranscode(file.Path, r.FormValue("profile")); err != nil {
 		Error(w, err)
 		return
 	}
+	if r.FormValue("next") == "transcodes" {
+		Redirect(w, r, "/transcodes")
+		return
+	}
 	Redirect(w, r, "/downloads/files/%s", dl.ID)
 }
 
@@ -487,7 +840,7 @@ func friendDownload(w http.ResponseWriter, r *http.Request, ps httprouter.Params
 		RawQuery: "friend=" + httpHost,
 	}
 
-	if err := StartTransfer(endpoint.String()); err != nil {
+	if err := StartTransfer(endpoint.String(), ps.ByName("user")); err != nil {
 		Error(w, err)
 		return
 	}
@@ -510,24 +863,453 @@ func settings(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	if r.Method == "GET" {
 		res := NewResponse(r, ps)
 		res.Section = "settings"
+		res.Subscriptions = subscriptions.List()
 		HTML(w, "settings.html", res)
 		return
 	}
 
-	ratio := strings.TrimSpace(r.FormValue("ratio"))
+	cfg := config.Get()
+	rules := parseSeedRules(r, cfg.SeedRules())
+	if err := config.SetSeedRules(rules); err != nil {
+		Error(w, err)
+		return
+	}
+	dler.Config.SetSeedRules(rules)
 
-	n, err := strconv.ParseFloat(ratio, 64)
-	if err == nil {
-		if err := config.SetRatio(n); err != nil {
+	selectFiles := r.FormValue("selectfiles") == "yes"
+	if err := config.SetSelectFiles(selectFiles); err != nil {
+		Error(w, err)
+		return
+	}
+	dler.Config.SetSelectFiles(selectFiles)
+
+	speed := func(key string, current int64) int64 {
+		n, err := strconv.ParseInt(strings.TrimSpace(r.FormValue(key)), 10, 64)
+		if err != nil || n < 0 {
+			return current
+		}
+		return n
+	}
+	if err := config.SetSpeeds(
+		speed("uploadspeed", cfg.UploadSpeed),
+		speed("downloadspeed", cfg.DownloadSpeed),
+		speed("turtleuploadspeed", cfg.TurtleUploadSpeed),
+		speed("turtledownloadspeed", cfg.TurtleDownloadSpeed),
+	); err != nil {
+		Error(w, err)
+		return
+	}
+	if err := config.SetTurtle(r.FormValue("turtle") == "yes"); err != nil {
+		Error(w, err)
+		return
+	}
+	applySpeeds()
+
+	if err := config.SetMinFree(speed("minfree", cfg.MinFree)); err != nil {
+		Error(w, err)
+		return
+	}
+	cfg = config.Get()
+	dler.Config.SetMinFree(cfg.MinFreeBytes())
+
+	autoconvert := r.FormValue("autoconvert") == "yes"
+	keeporiginals := r.FormValue("keeporiginals") == "yes"
+	if err := config.SetAutoConvert(autoconvert, keeporiginals); err != nil {
+		Error(w, err)
+		return
+	}
+	if profile := r.FormValue("transcodeprofile"); profile != "" {
+		if err := config.SetTranscodeProfile(profile); err != nil {
+			Error(w, err)
+			return
+		}
+	}
+
+	if err := config.SetRetention(r.FormValue("retention") == "yes", strings.TrimSpace(r.FormValue("archivedir"))); err != nil {
+		Error(w, err)
+		return
+	}
+
+	if days, err := strconv.Atoi(strings.TrimSpace(r.FormValue("trashdays"))); err == nil && days >= 0 {
+		if err := config.SetTrashDays(days); err != nil {
 			Error(w, err)
 			return
 		}
-		dler.Config.SetTorrentRatio(n)
 	}
 
 	Redirect(w, r, "/settings?message=settingssaved")
 }
 
+func turtleToggle(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := config.SetTurtle(!config.Get().Turtle); err != nil {
+		Error(w, err)
+		return
+	}
+	applySpeeds()
+	Redirect(w, r, "/import")
+}
+
+func speedRuleAdd(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		Error(w, err)
+		return
+	}
+	var days []time.Weekday
+	for _, v := range r.Form["days"] {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 6 {
+			days = append(days, time.Weekday(n))
+		}
+	}
+	start, _ := strconv.Atoi(r.FormValue("start"))
+	end, _ := strconv.Atoi(r.FormValue("end"))
+	up, _ := strconv.ParseInt(strings.TrimSpace(r.FormValue("uploadspeed")), 10, 64)
+	down, _ := strconv.ParseInt(strings.TrimSpace(r.FormValue("downloadspeed")), 10, 64)
+
+	rule, err := NewSpeedRule(days, start, end, up, down)
+	if err == nil {
+		err = config.AddSpeedRule(rule)
+	}
+	if err != nil {
+		res := NewResponse(r, ps)
+		res.Section = "settings"
+		res.Subscriptions = subscriptions.List()
+		res.Error = err.Error()
+		HTML(w, "settings.html", res)
+		return
+	}
+	applySpeeds()
+	Redirect(w, r, "/settings?message=settingssaved")
+}
+
+func speedRuleRemove(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := config.RemoveSpeedRule(ps.ByName("id")); err != nil {
+		Error(w, err)
+		return
+	}
+	applySpeeds()
+	Redirect(w, r, "/settings?message=settingssaved")
+}
+
+func convertRuleAdd(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rule, err := NewConvertRule(
+		strings.TrimSpace(r.FormValue("pattern")),
+		r.FormValue("convert") == "yes",
+		r.FormValue("keep") == "yes",
+	)
+	if err == nil {
+		err = config.AddConvertRule(rule)
+	}
+	if err != nil {
+		res := NewResponse(r, ps)
+		res.Section = "settings"
+		res.Subscriptions = subscriptions.List()
+		res.Error = err.Error()
+		HTML(w, "settings.html", res)
+		return
+	}
+	Redirect(w, r, "/settings?message=settingssaved")
+}
+
+func convertRuleRemove(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := config.RemoveConvertRule(ps.ByName("id")); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/settings?message=settingssaved")
+}
+
+func transcodeProfileAdd(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	crf, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("crf")))
+	maxHeight, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("maxheight")))
+	channels, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("audiochannels")))
+	profile := transcoder.Profile{
+		Name:          strings.TrimSpace(r.FormValue("name")),
+		VideoCodec:    r.FormValue("videocodec"),
+		CRF:           crf,
+		VideoBitrate:  strings.TrimSpace(r.FormValue("videobitrate")),
+		Preset:        r.FormValue("preset"),
+		MaxHeight:     maxHeight,
+		AudioCodec:    r.FormValue("audiocodec"),
+		AudioBitrate:  strings.TrimSpace(r.FormValue("audiobitrate")),
+		AudioChannels: channels,
+		Copy:          r.FormValue("copy") == "yes",
+		HLS:           r.FormValue("hls") == "yes",
+	}
+	if err := config.AddTranscodeProfile(profile); err != nil {
+		res := NewResponse(r, ps)
+		res.Section = "settings"
+		res.Subscriptions = subscriptions.List()
+		res.Error = err.Error()
+		HTML(w, "settings.html", res)
+		return
+	}
+	Redirect(w, r, "/settings?message=settingssaved")
+}
+
+func transcodeProfileRemove(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := config.RemoveTranscodeProfile(ps.ByName("id")); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/settings?message=settingssaved")
+}
+
+func retentionRuleAdd(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	maxAge, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("maxage")))
+	budget, _ := strconv.ParseInt(strings.TrimSpace(r.FormValue("budget")), 10, 64)
+	rule, err := NewRetentionRule(
+		maxAge,
+		r.FormValue("watched") == "yes",
+		r.FormValue("unshared") == "yes",
+		budget,
+		r.FormValue("action"),
+	)
+	if err == nil && rule.Action == RetentionArchive && config.Get().ArchiveDir == "" {
+		err = fmt.Errorf("set an archive directory before adding archive rules")
+	}
+	if err == nil {
+		err = config.AddRetentionRule(rule)
+	}
+	if err != nil {
+		res := NewResponse(r, ps)
+		res.Section = "settings"
+		res.Subscriptions = subscriptions.List()
+		res.Error = err.Error()
+		HTML(w, "settings.html", res)
+		return
+	}
+	Redirect(w, r, "/settings?message=settingssaved")
+}
+
+func retentionRuleRemove(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := config.RemoveRetentionRule(ps.ByName("id")); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/settings?message=settingssaved")
+}
+
+// retention shows what the retention rules would clean up, and runs them on POST.
+func retention(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	items, err := PlanRetention()
+	if err != nil {
+		Error(w, err)
+		return
+	}
+
+	res := NewResponse(r, ps)
+	res.Section = "settings"
+	if r.Method == "POST" {
+		items = ApplyRetention(items)
+		res.RetentionApplied = true
+	}
+	res.Retention = items
+	HTML(w, "retention.html", res)
+}
+
+func tokenAdd(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	_, secret, err := tokens.Create(strings.TrimSpace(r.FormValue("name")), r.FormValue("scope"))
+
+	res := NewResponse(r, ps)
+	res.Section = "settings"
+	res.Subscriptions = subscriptions.List()
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		// The secret is only shown this once.
+		res.NewToken = secret
+	}
+	HTML(w, "settings.html", res)
+}
+
+func tokenRemove(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := tokens.Revoke(ps.ByName("id")); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/settings?message=tokenrevoked")
+}
+
+func login(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Behind a reverse proxy, the proxy handles logins.
+	if reverseProxyAuthIP != "" {
+		Redirect(w, r, "/")
+		return
+	}
+	next := loginRedirect(r.FormValue("next"))
+	if _, ok := sessions.Find(sessionSecret(r)); ok && r.Method == "GET" {
+		http.Redirect(w, r, next, http.StatusFound)
+		return
+	}
+
+	res := NewResponse(r, ps)
+	res.Section = "login"
+	if r.Method == "GET" {
+		HTML(w, "login.html", res)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("username"))
+	password := r.FormValue("password")
+	ok := name == httpUsername && password == authsecret.Get()
+	if !ok {
+		_, ok = users.Authenticate(name, password)
+	}
+	if !ok {
+		logger.Errorf("login: failed for user %q", name)
+		time.Sleep(loginFailureDelay)
+		res.Error = "Wrong user name or password"
+		HTML(w, "login.html", res)
+		return
+	}
+
+	session, secret, err := sessions.Create(name)
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	setSessionCookie(w, r, secret, session.Expires)
+	logger.Infof("login: user %q logged in", name)
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+func logout(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if id := ps.ByName("session"); id != "" {
+		if err := sessions.Revoke(id); err != nil {
+			Error(w, err)
+			return
+		}
+	}
+	clearSessionCookie(w, r)
+	logger.Infof("login: user %q logged out", ps.ByName("user"))
+	Redirect(w, r, "/login?message=loggedout")
+}
+
+func usersList(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	res := NewResponse(r, ps)
+	res.Section = "users"
+	res.Users = users.List()
+	HTML(w, "users.html", res)
+}
+
+func userAdd(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if err := users.Add(name, r.FormValue("password"), r.FormValue("role")); err != nil {
+		res := NewResponse(r, ps)
+		res.Section = "users"
+		res.Users = users.List()
+		res.Error = err.Error()
+		HTML(w, "users.html", res)
+		return
+	}
+	logger.Infof("users: %q added %q as %s", ps.ByName("user"), name, r.FormValue("role"))
+	Redirect(w, r, "/users?message=useradded")
+}
+
+func userUpdate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	err := users.SetRole(id, r.FormValue("role"))
+	if err == nil && r.FormValue("password") != "" {
+		err = users.SetPassword(id, r.FormValue("password"))
+	}
+	if err != nil {
+		res := NewResponse(r, ps)
+		res.Section = "users"
+		res.Users = users.List()
+		res.Error = err.Error()
+		HTML(w, "users.html", res)
+		return
+	}
+	if r.FormValue("password") != "" {
+		if u, ok := users.Get(id); ok {
+			if err := sessions.RevokeUser(u.Name); err != nil {
+				Error(w, err)
+				return
+			}
+		}
+	}
+	logger.Infof("users: %q updated %q", ps.ByName("user"), id)
+	Redirect(w, r, "/users?message=usersaved")
+}
+
+func userRemove(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if u, ok := users.Get(ps.ByName("id")); ok {
+		if err := sessions.RevokeUser(u.Name); err != nil {
+			Error(w, err)
+			return
+		}
+	}
+	if err := users.Remove(ps.ByName("id")); err != nil {
+		Error(w, err)
+		return
+	}
+	logger.Infof("users: %q removed %q", ps.ByName("user"), ps.ByName("id"))
+	Redirect(w, r, "/users?message=userremoved")
+}
+
+func hookAdd(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		Error(w, err)
+		return
+	}
+	hook, err := NewHook(r.Form["events"], strings.TrimSpace(r.FormValue("url")), strings.TrimSpace(r.FormValue("command")))
+	if err == nil {
+		err = config.AddHook(hook)
+	}
+	if err != nil {
+		res := NewResponse(r, ps)
+		res.Section = "settings"
+		res.Subscriptions = subscriptions.List()
+		res.Error = err.Error()
+		HTML(w, "settings.html", res)
+		return
+	}
+	Redirect(w, r, "/settings?message=hookadded")
+}
+
+func hookRemove(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := config.RemoveHook(ps.ByName("id")); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/settings?message=hookremoved")
+}
+
+func subscriptionAdd(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	megabytes := func(key string) int64 {
+		n, _ := strconv.ParseInt(strings.TrimSpace(r.FormValue(key)), 10, 64)
+		return n * 1024 * 1024
+	}
+	interval, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("interval")))
+
+	sub := Subscription{
+		Name:     strings.TrimSpace(r.FormValue("name")),
+		URL:      strings.TrimSpace(r.FormValue("url")),
+		Include:  strings.TrimSpace(r.FormValue("include")),
+		Exclude:  strings.TrimSpace(r.FormValue("exclude")),
+		MinSize:  megabytes("minsize"),
+		MaxSize:  megabytes("maxsize"),
+		Interval: interval,
+	}
+	if err := subscriptions.Add(sub); err != nil {
+		res := NewResponse(r, ps)
+		res.Section = "settings"
+		res.Subscriptions = subscriptions.List()
+		res.Error = err.Error()
+		HTML(w, "settings.html", res)
+		return
+	}
+	Redirect(w, r, "/settings?message=subscriptionadded")
+}
+
+func subscriptionRemove(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := subscriptions.Remove(ps.ByName("id")); err != nil {
+		Error(w, err)
+		return
+	}
+	Redirect(w, r, "/settings?message=subscriptionremoved")
+}
+
 //
 // Search
 //
@@ -546,7 +1328,7 @@ func importHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	if query != "" {
 		// Add URL
 		if strings.HasPrefix(query, "http") || strings.HasPrefix(query, "magnet") {
-			if err := StartTransfer(query); err != nil {
+			if err := StartTransfer(query, ps.ByName("user")); err != nil {
 				Error(w, err)
 				return
 			}
@@ -676,6 +1458,9 @@ func feedPodcast(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 				item.AddImage(fmt.Sprintf("%s/feed/stream/%s/%s.thumbnail.png?secret=%s", baseurl, dl.ID, file.ID, feedsecret.Get()))
 			}
 			item.AddEnclosure(stream.String(), typ, size)
+			if d := file.Duration(); d > 0 {
+				item.AddDuration(int64(d / time.Second))
+			}
 			if _, err := p.AddItem(item); err != nil {
 				Error(w, err)
 				return
@@ -724,6 +1509,11 @@ func feedStream(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		http.NotFound(w, r)
 		return
 	}
+	if r.Method == "GET" {
+		if err := dl.Watch(); err != nil {
+			logger.Warnf("recording %q as watched failed: %s", dl.ID, err)
+		}
+	}
 
 	w.Header().Set("Vary", "Accept-Encoding")
 	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", 7*86400))
@@ -790,13 +1580,45 @@ func v1Files(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		return
 	}
 
+	// Hashing a big download takes a while, so only cached hashes are sent and
+	// the rest are hashed in the background. Friends skip verifying files without one.
 	var files []FriendFile
+	pending := false
 	for _, f := range dl.Files(false) {
+		hash, ok := f.CachedHash()
+		if !ok {
+			pending = true
+		}
 		files = append(files, FriendFile{
 			ID:   f.ID,
 			Size: f.Info.Size(),
+			Hash: hash,
 		})
 	}
+	if pending {
+		go dl.HashFiles()
+	}
+	JSON(w, files)
+}
+
+func v1TransferFiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if r.Method == "POST" {
+		priorities, err := parseFilePriorities(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := SetTransferFiles(ps.ByName("id"), priorities); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	files, err := ListTransferFiles(ps.ByName("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
 	JSON(w, files)
 }
 
@@ -912,6 +1734,36 @@ func main() {
 		logger.Fatal(err)
 	}
 
+	// feed subscriptions
+	subscriptions, err = NewSubscriptions("subscriptions.json")
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	// API tokens
+	tokens, err = NewTokens("tokens.json")
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	// User accounts
+	users, err = NewUsers("users.json")
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	// Login sessions
+	sessions, err = NewSessions("sessions.json")
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	// Transcode history
+	transcodeHistory, err = NewTranscodeHistory("transcodes.json")
+	if err != nil {
+		logger.Fatal(err)
+	}
+
 	if httpHost == "" {
 		usage("missing HTTP host")
 		os.Exit(1)
@@ -936,13 +1788,23 @@ func main() {
 
 	// transcoder
 	tcer = transcoder.NewTranscoder()
+	tcer.Finished = transcodeEvent
 
 	// downloader
 	logger.Debugf("download directory is %q", downloadDir)
 
+	if watchDir == "" {
+		watchDir = filepath.Join(downloadDir, ".blackhole")
+	}
+	logger.Debugf("watch directory is %q", watchDir)
+
+	cfg := config.Get()
 	dler, err = downloader.NewDownloader(&downloader.Config{
 		DownloadDir: downloadDir,
 		TorrentAddr: torrentListenAddr,
+		WatchDir:    watchDir,
+		Events:      transferEvent,
+		Convert:     autoConvert,
 		Logger:      logger,
 		Space: func() int64 {
 			di, err := NewDiskInfo(downloadDir)
@@ -951,12 +1813,29 @@ func main() {
 			}
 			return di.Free()
 		},
-		TorrentRatio: config.Get().Ratio,
+		TorrentRatio: cfg.Ratio,
+		SeedMinTime:  cfg.SeedRules().MinTime,
+		SeedMaxTime:  cfg.SeedRules().MaxTime,
+		SeedIdleTime: cfg.SeedRules().IdleTime,
+		SelectFiles:  cfg.SelectFiles,
+		MinFree:      cfg.MinFreeBytes(),
 	})
 	if err != nil {
 		logger.Fatal(err)
 	}
 
+	// Apply the speed limits and their schedule.
+	go scheduleSpeeds()
+
+	// Clean up the library by the retention rules.
+	go runRetention()
+
+	// Purge old downloads from the trash.
+	go purgeTrash()
+
+	// Check feed subscriptions for new transfers.
+	go subscriptions.Poll()
+
 	// friends dir
 	if !metadata {
 		friendsDir = filepath.Join(downloadDir, ".friends")
@@ -984,59 +1863,138 @@ func main() {
 	*/
 
 	// Downloads
-	r.GET("/", Log(Auth(index, false)))
-	r.GET(Prefix(""), Log(Auth(index, false)))
-	r.GET(Prefix("/"), Log(Auth(library, false)))
-	r.GET(Prefix("/logs"), Auth(logs, false))
-	r.GET(Prefix("/downloads/list"), Auth(dlList, false))
-	r.GET(Prefix("/downloads/files/:id"), Log(Auth(dlFiles, false)))
-	r.GET(Prefix("/downloads/view/:id/*file"), Log(Auth(dlView, false)))
-	r.GET(Prefix("/downloads/save/:id/*file"), Log(Auth(dlSave, false)))
-	r.GET(Prefix("/downloads/stream/:id/*file"), Log(Auth(dlStream, false)))
-	r.GET(Prefix("/downloads/remove/:id"), Log(Auth(dlRemove, false)))
-	r.POST(Prefix("/downloads/share/:id"), Log(Auth(dlShare, false)))
-	r.POST(Prefix("/downloads/unshare/:id"), Log(Auth(dlUnshare, false)))
+	r.GET("/", Log(Auth(Require(RoleViewer, index), false)))
+	r.GET(Prefix(""), Log(Auth(Require(RoleViewer, index), false)))
+	r.GET(Prefix("/"), Log(Auth(Require(RoleViewer, library), false)))
+	r.GET(Prefix("/logs"), Auth(Require(RoleAdmin, logs), false))
+	r.GET(Prefix("/downloads/list"), Auth(Require(RoleViewer, dlList), false))
+	r.GET(Prefix("/downloads/files/:id"), Log(Auth(Require(RoleViewer, dlFiles), false)))
+	r.GET(Prefix("/downloads/view/:id/*file"), Log(Auth(Require(RoleViewer, dlView), false)))
+	r.GET(Prefix("/downloads/save/:id/*file"), Log(Auth(Require(RoleViewer, dlSave), false)))
+	r.GET(Prefix("/downloads/stream/:id/*file"), Log(Auth(Require(RoleViewer, dlStream), false)))
+	r.HEAD(Prefix("/downloads/hls/:id/*file"), Log(Auth(Require(RoleViewer, dlHLS), false)))
+	r.GET(Prefix("/downloads/hls/:id/*file"), Log(Auth(Require(RoleViewer, dlHLS), false)))
+	r.POST(Prefix("/downloads/remove/:id"), Log(Auth(Require(RoleMember, dlRemove), false)))
+	r.POST(Prefix("/downloads/share/:id"), Log(Auth(Require(RoleMember, dlShare), false)))
+	r.POST(Prefix("/downloads/unshare/:id"), Log(Auth(Require(RoleMember, dlUnshare), false)))
 
 	// Transfers
-	r.GET(Prefix("/transfers/list"), Auth(transferList, false))
-	r.GET(Prefix("/transfers/cancel/:id"), Log(Auth(transferCancel, false)))
-	r.POST(Prefix("/transfers/start"), Log(Auth(transferStart, false)))
-	r.POST(Prefix("/transfers/magnet"), Log(Auth(transferMagnet, false)))
+	r.GET(Prefix("/transfers/list"), Auth(Require(RoleMember, transferList), false))
+	r.POST(Prefix("/transfers/cancel/:id"), Log(Auth(Require(RoleMember, transferCancel), false)))
+	r.POST(Prefix("/transfers/pause/:id"), Log(Auth(Require(RoleMember, transferPause), false)))
+	r.POST(Prefix("/transfers/resume/:id"), Log(Auth(Require(RoleMember, transferResume), false)))
+	r.POST(Prefix("/transfers/top/:id"), Log(Auth(Require(RoleMember, transferTop), false)))
+	r.POST(Prefix("/transfers/bottom/:id"), Log(Auth(Require(RoleMember, transferBottom), false)))
+	r.POST(Prefix("/transfers/priority/:id"), Log(Auth(Require(RoleMember, transferPriority), false)))
+	r.GET(Prefix("/transfers/files/:id"), Log(Auth(Require(RoleMember, transferFiles), false)))
+	r.POST(Prefix("/transfers/files/:id"), Log(Auth(Require(RoleMember, transferFiles), false)))
+	r.GET(Prefix("/transfers/seed/:id"), Log(Auth(Require(RoleMember, transferSeed), false)))
+	r.POST(Prefix("/transfers/seed/:id"), Log(Auth(Require(RoleMember, transferSeed), false)))
+	r.GET(Prefix("/transfers/view/:id/:index"), Log(Auth(Require(RoleViewer, transferView), false)))
+	r.HEAD(Prefix("/transfers/stream/:id/:index"), Log(Auth(Require(RoleViewer, transferStream), false)))
+	r.GET(Prefix("/transfers/stream/:id/:index"), Log(Auth(Require(RoleViewer, transferStream), false)))
+	r.POST(Prefix("/transfers/start"), Log(Auth(Require(RoleMember, transferStart), false)))
+	r.POST(Prefix("/transfers/magnet"), Log(Auth(Require(RoleMember, transferMagnet), false)))
 
 	// Transcodings
-	r.GET(Prefix("/transcode/start/:id/*file"), Log(Auth(transcodeStart, false)))
-	r.GET(Prefix("/transcode/cancel/:id/*file"), Log(Auth(transcodeCancel, false)))
+	r.GET(Prefix("/transcodes"), Log(Auth(Require(RoleMember, transcodes), false)))
+	r.GET(Prefix("/transcodes/list"), Auth(Require(RoleMember, transcodeList), false))
+	r.POST(Prefix("/transcodes/clear"), Log(Auth(Require(RoleMember, transcodesClear), false)))
+	r.POST(Prefix("/transcode/start/:id/*file"), Log(Auth(Require(RoleMember, transcodeStart), false)))
+	r.POST(Prefix("/transcode/cancel/:id/*file"), Log(Auth(Require(RoleMember, transcodeCancel), false)))
 
 	// Friends
-	r.GET(Prefix("/friends"), Log(Auth(friends, true)))
-	r.POST(Prefix("/friends/add"), Log(Auth(friendAdd, true)))
-	r.GET(Prefix("/friends/remove/:host"), Log(Auth(friendRemove, true)))
-	r.POST(Prefix("/friends/download/:host/:dl"), Log(Auth(friendDownload, true)))
+	r.GET(Prefix("/friends"), Log(Auth(Require(RoleMember, friends), true)))
+	r.POST(Prefix("/friends/add"), Log(Auth(Require(RoleAdmin, friendAdd), true)))
+	r.POST(Prefix("/friends/remove/:host"), Log(Auth(Require(RoleAdmin, friendRemove), true)))
+	r.POST(Prefix("/friends/download/:host/:dl"), Log(Auth(Require(RoleMember, friendDownload), true)))
 
 	// Feed
 	r.GET(Prefix("/feed"), Log(feedIndex))
 	r.GET(Prefix("/podcast/:secret"), Log(feedPodcast))
 	r.HEAD(Prefix("/feed/stream/:id/*file"), Log(feedStream))
 	r.GET(Prefix("/feed/stream/:id/*file"), Log(feedStream))
-	r.GET(Prefix("/feed/reset"), Log(Auth(feedReset, false)))
+	r.POST(Prefix("/feed/reset"), Log(Auth(Require(RoleAdmin, feedReset), false)))
+
+	// Trash
+	r.GET(Prefix("/trash"), Log(Auth(Require(RoleMember, trash), false)))
+	r.POST(Prefix("/trash/restore/:id"), Log(Auth(Require(RoleMember, trashRestore), false)))
+	r.POST(Prefix("/trash/purge/:id"), Log(Auth(Require(RoleMember, trashPurge), false)))
+	r.POST(Prefix("/trash/empty"), Log(Auth(Require(RoleMember, trashEmpty), false)))
 
 	// Settings
-	r.GET(Prefix("/settings"), Log(Auth(settings, false)))
-	r.POST(Prefix("/settings"), Log(Auth(settings, false)))
-	r.GET(Prefix("/help"), Log(Auth(help, false)))
+	r.GET(Prefix("/settings"), Log(Auth(Require(RoleAdmin, settings), false)))
+	r.POST(Prefix("/settings"), Log(Auth(Require(RoleAdmin, settings), false)))
+	r.POST(Prefix("/settings/subscriptions/add"), Log(Auth(Require(RoleAdmin, subscriptionAdd), false)))
+	r.POST(Prefix("/settings/hooks/add"), Log(Auth(Require(RoleAdmin, hookAdd), false)))
+	r.POST(Prefix("/settings/turtle"), Log(Auth(Require(RoleAdmin, turtleToggle), false)))
+	r.POST(Prefix("/settings/speeds/add"), Log(Auth(Require(RoleAdmin, speedRuleAdd), false)))
+	r.POST(Prefix("/settings/speeds/remove/:id"), Log(Auth(Require(RoleAdmin, speedRuleRemove), false)))
+	r.POST(Prefix("/settings/convert/add"), Log(Auth(Require(RoleAdmin, convertRuleAdd), false)))
+	r.POST(Prefix("/settings/convert/remove/:id"), Log(Auth(Require(RoleAdmin, convertRuleRemove), false)))
+	r.POST(Prefix("/settings/profiles/add"), Log(Auth(Require(RoleAdmin, transcodeProfileAdd), false)))
+	r.POST(Prefix("/settings/profiles/remove/:id"), Log(Auth(Require(RoleAdmin, transcodeProfileRemove), false)))
+	r.POST(Prefix("/settings/retention/add"), Log(Auth(Require(RoleAdmin, retentionRuleAdd), false)))
+	r.POST(Prefix("/settings/retention/remove/:id"), Log(Auth(Require(RoleAdmin, retentionRuleRemove), false)))
+	r.GET(Prefix("/retention"), Log(Auth(Require(RoleAdmin, retention), false)))
+	r.POST(Prefix("/retention"), Log(Auth(Require(RoleAdmin, retention), false)))
+	r.POST(Prefix("/settings/hooks/remove/:id"), Log(Auth(Require(RoleAdmin, hookRemove), false)))
+	r.POST(Prefix("/settings/tokens/add"), Log(Auth(Require(RoleAdmin, tokenAdd), false)))
+	r.POST(Prefix("/settings/tokens/remove/:id"), Log(Auth(Require(RoleAdmin, tokenRemove), false)))
+
+	// Users
+	r.GET(Prefix("/users"), Log(Auth(Require(RoleAdmin, usersList), false)))
+	r.POST(Prefix("/users/add"), Log(Auth(Require(RoleAdmin, userAdd), false)))
+	r.POST(Prefix("/users/update/:id"), Log(Auth(Require(RoleAdmin, userUpdate), false)))
+	r.POST(Prefix("/users/remove/:id"), Log(Auth(Require(RoleAdmin, userRemove), false)))
+	r.POST(Prefix("/settings/subscriptions/remove/:id"), Log(Auth(Require(RoleAdmin, subscriptionRemove), false)))
+	r.GET(Prefix("/help"), Log(Auth(Require(RoleViewer, help), false)))
+
+	// Login
+	r.GET(Prefix("/login"), Log(login))
+	r.POST(Prefix("/login"), Log(login))
+	r.POST(Prefix("/logout"), Log(Auth(Require(RoleViewer, logout), false)))
 
 	// Import
-	r.GET(Prefix("/import"), Log(Auth(importHandler, false)))
+	r.GET(Prefix("/import"), Log(Auth(Require(RoleMember, importHandler), false)))
 
 	// API v1
 	r.GET(Prefix("/v1/status"), Log(v1Status))
-	r.GET(Prefix("/v1/downloads"), Log(Auth(v1Downloads, true)))
-	r.GET(Prefix("/v1/downloads/files/:id"), Log(Auth(v1Files, true)))
-	r.HEAD(Prefix("/v1/downloads/stream/:id/*file"), Log(Auth(v1Stream, true)))
-	r.GET(Prefix("/v1/downloads/stream/:id/*file"), Log(Auth(v1Stream, true)))
+	r.GET(Prefix("/v1/downloads"), Log(Auth(Require(RoleViewer, v1Downloads), true)))
+	r.GET(Prefix("/v1/downloads/files/:id"), Log(Auth(Require(RoleViewer, v1Files), true)))
+	r.HEAD(Prefix("/v1/downloads/stream/:id/*file"), Log(Auth(Require(RoleViewer, v1Stream), true)))
+	r.GET(Prefix("/v1/downloads/stream/:id/*file"), Log(Auth(Require(RoleViewer, v1Stream), true)))
+	r.GET(Prefix("/v1/transfers/files/:id"), Log(Auth(Require(RoleMember, v1TransferFiles), false)))
+	r.POST(Prefix("/v1/transfers/files/:id"), Log(Auth(Require(RoleMember, v1TransferFiles), false)))
+
+	// JSON API
+	r.GET(Prefix("/api/openapi.json"), Log(Auth(Require(RoleViewer, apiSpec), false)))
+	r.GET(Prefix("/api/downloads"), Log(Auth(Require(RoleViewer, apiDownloads), false)))
+	r.GET(Prefix("/api/downloads/:id"), Log(Auth(Require(RoleViewer, apiDownload), false)))
+	r.DELETE(Prefix("/api/downloads/:id"), Log(Auth(Require(RoleMember, apiDownloadRemove), false)))
+	r.POST(Prefix("/api/downloads/:id/share"), Log(Auth(Require(RoleMember, apiDownloadShare), false)))
+	r.DELETE(Prefix("/api/downloads/:id/share"), Log(Auth(Require(RoleMember, apiDownloadShare), false)))
+	r.GET(Prefix("/api/transfers"), Log(Auth(Require(RoleMember, apiTransfers), false)))
+	r.POST(Prefix("/api/transfers"), Log(Auth(Require(RoleMember, apiTransferAdd), false)))
+	r.GET(Prefix("/api/transfers/:id"), Log(Auth(Require(RoleMember, apiTransfer), false)))
+	r.DELETE(Prefix("/api/transfers/:id"), Log(Auth(Require(RoleMember, apiTransferCancel), false)))
+	r.POST(Prefix("/api/transfers/:id/pause"), Log(Auth(Require(RoleMember, apiTransferPause), false)))
+	r.POST(Prefix("/api/transfers/:id/resume"), Log(Auth(Require(RoleMember, apiTransferResume), false)))
+	r.GET(Prefix("/api/transcodes"), Log(Auth(Require(RoleMember, apiTranscodes), false)))
+	r.GET(Prefix("/api/transcodes/history"), Log(Auth(Require(RoleMember, apiTranscodeHistory), false)))
+	r.GET(Prefix("/api/transcodes/profiles"), Log(Auth(Require(RoleMember, apiProfiles), false)))
+	r.POST(Prefix("/api/transcodes/:id/*file"), Log(Auth(Require(RoleMember, apiTranscode), false)))
+	r.DELETE(Prefix("/api/transcodes/:id/*file"), Log(Auth(Require(RoleMember, apiTranscode), false)))
+	r.GET(Prefix("/api/friends"), Log(Auth(Require(RoleMember, apiFriends), false)))
+	r.POST(Prefix("/api/friends"), Log(Auth(Require(RoleAdmin, apiFriendAdd), false)))
+	r.DELETE(Prefix("/api/friends/:host"), Log(Auth(Require(RoleAdmin, apiFriendRemove), false)))
+	r.GET(Prefix("/api/friends/:host/downloads"), Log(Auth(Require(RoleMember, apiFriendDownloads), false)))
+	r.POST(Prefix("/api/friends/:host/downloads/:dl"), Log(Auth(Require(RoleMember, apiFriendDownload), false)))
+	r.GET(Prefix("/api/settings"), Log(Auth(Require(RoleAdmin, apiSettings), false)))
+	r.PATCH(Prefix("/api/settings"), Log(Auth(Require(RoleAdmin, apiSettingsUpdate), false)))
 
 	// Assets
-	r.GET(Prefix("/static/*path"), Auth(staticAsset, false))
+	r.GET(Prefix("/static/*path"), staticAsset)
 	r.GET(Prefix("/logo.png"), logo)
 
 	//
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Retention actions.
+const (
+	RetentionDelete  = "delete"
+	RetentionArchive = "archive"
+)
+
+var retentionInterval = 1 * time.Hour
+
+// RetentionRule deletes or archives downloads that meet all of its conditions.
+type RetentionRule struct {
+	ID       string `json:"id"`
+	MaxAge   int    `json:"max_age"`  // days
+	Watched  bool   `json:"watched"`  // only downloads that have been watched
+	Unshared bool   `json:"unshared"` // only downloads not shared with friends
+	Budget   int64  `json:"budget"`   // gigabytes; evict the least recently watched until the library fits
+	Action   string `json:"action"`
+}
+
+func NewRetentionRule(maxAge int, watched, unshared bool, budget int64, action string) (RetentionRule, error) {
+	if maxAge < 0 || budget < 0 {
+		return RetentionRule{}, fmt.Errorf("age and budget can't be negative")
+	}
+	if maxAge == 0 && !watched && !unshared && budget == 0 {
+		return RetentionRule{}, fmt.Errorf("a rule needs at least one condition")
+	}
+	if action != RetentionDelete && action != RetentionArchive {
+		return RetentionRule{}, fmt.Errorf("unknown action %q", action)
+	}
+	rule := RetentionRule{
+		MaxAge:   maxAge,
+		Watched:  watched,
+		Unshared: unshared,
+		Budget:   budget,
+		Action:   action,
+	}
+	rule.ID = fmt.Sprintf("%x", md5.Sum([]byte(rule.String())))
+	return rule, nil
+}
+
+func (r RetentionRule) String() string {
+	var conds []string
+	if r.MaxAge > 0 {
+		conds = append(conds, fmt.Sprintf("older than %d days", r.MaxAge))
+	}
+	if r.Watched {
+		conds = append(conds, "watched")
+	}
+	if r.Unshared {
+		conds = append(conds, "not shared")
+	}
+	if r.Budget > 0 {
+		conds = append(conds, fmt.Sprintf("library over %d GB", r.Budget))
+	}
+	return fmt.Sprintf("%s %s", r.Action, strings.Join(conds, ", "))
+}
+
+// Match returns true if the download meets the age, watched and shared conditions.
+// The budget is checked against the whole library by PlanRetention.
+func (r RetentionRule) Match(dl Download, now time.Time) bool {
+	if r.MaxAge > 0 && now.Sub(dl.Created) < time.Duration(r.MaxAge)*24*time.Hour {
+		return false
+	}
+	if r.Watched && !dl.Watched() {
+		return false
+	}
+	if r.Unshared && dl.Shared() {
+		return false
+	}
+	return true
+}
+
+// RetentionItem is a download a retention run would delete or archive.
+type RetentionItem struct {
+	Download Download
+	Rule     RetentionRule
+	Size     int64
+	Error    string
+}
+
+// PlanRetention returns what a retention run would do, without changing anything.
+// Downloads that are still downloading, seeding or transcoding are never touched.
+func PlanRetention() ([]RetentionItem, error) {
+	dls, err := ListDownloads()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sizes := make(map[string]int64)
+	var total int64
+	for _, dl := range dls {
+		sizes[dl.ID] = dl.Size()
+		total += sizes[dl.ID]
+	}
+
+	// The least recently used go first; downloads never watched count from when they were added.
+	lastUsed := func(dl Download) time.Time {
+		if t := dl.LastWatched(); !t.IsZero() {
+			return t
+		}
+		return dl.Created
+	}
+	sort.SliceStable(dls, func(i, j int) bool { return lastUsed(dls[i]).Before(lastUsed(dls[j])) })
+
+	var items []RetentionItem
+	planned := make(map[string]bool)
+	for _, rule := range config.Get().RetentionRules {
+		budget := rule.Budget * 1024 * 1024 * 1024
+		for _, dl := range dls {
+			if planned[dl.ID] || !retainable(dl) || !rule.Match(dl, now) {
+				continue
+			}
+			if rule.Budget > 0 && total <= budget {
+				break
+			}
+			planned[dl.ID] = true
+			total -= sizes[dl.ID]
+			items = append(items, RetentionItem{Download: dl, Rule: rule, Size: sizes[dl.ID]})
+		}
+	}
+	return items, nil
+}
+
+// retainable returns false for downloads still in use by a transfer or a transcode.
+func retainable(dl Download) bool {
+	if dl.Downloading() || dl.Uploading() {
+		return false
+	}
+	for _, f := range dl.Files(false) {
+		if ActiveTranscode(f.Path) {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyRetention deletes or archives the planned downloads, recording any errors in the items.
+func ApplyRetention(items []RetentionItem) []RetentionItem {
+	archiveDir := config.Get().ArchiveDir
+	for i, item := range items {
+		dl := item.Download
+		// Check again, in case a transfer started since the plan was made.
+		if !retainable(dl) {
+			items[i].Error = "in use"
+			continue
+		}
+
+		var err error
+		switch item.Rule.Action {
+		case RetentionArchive:
+			if archiveDir == "" {
+				err = fmt.Errorf("no archive directory set")
+				break
+			}
+			err = moveDir(dl.Path(), filepath.Join(archiveDir, dl.ID))
+		default:
+			err = os.RemoveAll(dl.Path())
+		}
+		if err != nil {
+			logger.Errorf("retention: %s %q failed: %s", item.Rule.Action, dl.ID, err)
+			items[i].Error = err.Error()
+			continue
+		}
+		dl.Unshare()
+		os.Remove(dl.Watchedfile())
+		logger.Infof("retention: %s %q (%s)", item.Rule.Action, dl.ID, item.Rule)
+	}
+	return items
+}
+
+// runRetention applies the retention rules periodically while enabled.
+func runRetention() {
+	for {
+		time.Sleep(retentionInterval)
+		if !config.Get().Retention {
+			continue
+		}
+		items, err := PlanRetention()
+		if err != nil {
+			logger.Errorf("retention: %s", err)
+			continue
+		}
+		ApplyRetention(items)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sessionCookie = "viewscreen_session"
+
+// Sessions expire this long after logging in.
+var sessionLifetime = 14 * 24 * time.Hour
+
+// Failed logins are slowed down to make guessing passwords impractical.
+var loginFailureDelay = 1 * time.Second
+
+// Session is a login from the login page. Only the hash of the cookie value is stored.
+type Session struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+type Sessions struct {
+	sync.RWMutex
+	filename string
+
+	Key   string    `json:"key"` // signs CSRF tokens
+	Items []Session `json:"sessions"`
+}
+
+func NewSessions(filename string) (*Sessions, error) {
+	filename = filepath.Join(downloadDir, filename)
+	s := &Sessions{filename: filename}
+	b, err := ioutil.ReadFile(filename)
+
+	// Default for new sessions
+	if os.IsNotExist(err) {
+		key, err := randomHex(32)
+		if err != nil {
+			return nil, err
+		}
+		s.Key = key
+		return s, s.Save()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Open existing sessions
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create starts a session for the user and returns the cookie value, which is not stored.
+func (s *Sessions) Create(user string) (Session, string, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return Session{}, "", err
+	}
+	now := time.Now()
+	session := Session{
+		ID:      tokenHash(secret),
+		User:    user,
+		Created: now,
+		Expires: now.Add(sessionLifetime),
+	}
+
+	s.Lock()
+	var keep []Session
+	for _, existing := range s.Items {
+		if now.Before(existing.Expires) {
+			keep = append(keep, existing)
+		}
+	}
+	s.Items = append(keep, session)
+	s.Unlock()
+	return session, secret, s.Save()
+}
+
+// Find returns the unexpired session with the cookie value.
+func (s *Sessions) Find(secret string) (Session, bool) {
+	id := tokenHash(secret)
+	now := time.Now()
+
+	s.RLock()
+	defer s.RUnlock()
+	for _, session := range s.Items {
+		if subtle.ConstantTimeCompare([]byte(session.ID), []byte(id)) == 1 && now.Before(session.Expires) {
+			return session, true
+		}
+	}
+	return Session{}, false
+}
+
+func (s *Sessions) Revoke(id string) error {
+	return s.remove(func(session Session) bool { return session.ID == id })
+}
+
+// RevokeUser logs the user out everywhere.
+func (s *Sessions) RevokeUser(user string) error {
+	return s.remove(func(session Session) bool { return session.User == user })
+}
+
+func (s *Sessions) remove(match func(Session) bool) error {
+	s.Lock()
+	var keep []Session
+	for _, session := range s.Items {
+		if match(session) {
+			continue
+		}
+		keep = append(keep, session)
+	}
+	s.Items = keep
+	s.Unlock()
+	return s.Save()
+}
+
+// CSRF returns the CSRF token for a session ID, or for a user logged in by the reverse proxy.
+func (s *Sessions) CSRF(id string) string {
+	s.RLock()
+	key := s.Key
+	s.RUnlock()
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Sessions) Save() error {
+	s.RLock()
+	defer s.RUnlock()
+
+	b, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	return Overwrite(s.filename, b, 0600)
+}
+
+// sessionSecret returns the value of the session cookie, if any.
+func sessionSecret(r *http.Request) string {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, secret string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    secret,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   letsencrypt || r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	setSessionCookie(w, r, "", time.Unix(0, 0))
+}
+
+// sessionRole returns the current role of a logged in user, so role changes take effect immediately.
+func sessionRole(user string) (string, bool) {
+	if user == httpUsername {
+		return RoleAdmin, true
+	}
+	if u, ok := users.Find(user); ok {
+		return u.Role, true
+	}
+	return "", false
+}
+
+// validCSRF returns true if the request carries the expected CSRF token,
+// in an X-CSRF-Token header (for AJAX) or a "csrf" form field.
+func validCSRF(r *http.Request, expected string) bool {
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.FormValue("csrf")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// loginRedirect returns where to go after logging in; only local paths are allowed.
+func loginRedirect(next string) string {
+	if !strings.HasPrefix(next, Prefix("/")) || strings.HasPrefix(next, "//") || strings.Contains(next, "\\") {
+		return Prefix("/")
+	}
+	return next
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -37,3 +37,27 @@ window.poller = function(target, url, delay) {
 	p();
 };
 
+// hlsPlayer plays the HLS playlist source of the video element. Browsers that play HLS
+// themselves (Safari, iOS) use the source as is; others use hls.js when they have
+// Media Source Extensions. If neither works, the video's other sources are used.
+window.hlsPlayer = function(video) {
+	var source = video.querySelector('source[type="application/vnd.apple.mpegurl"]');
+	if (!source || video.canPlayType('application/vnd.apple.mpegurl')) {
+		return;
+	}
+	if (typeof Hls === 'undefined' || !Hls.isSupported()) {
+		return;
+	}
+	var hls = new Hls();
+	hls.on(Hls.Events.ERROR, function(event, data) {
+		if (!data.fatal) {
+			return;
+		}
+		// Fall back to the file itself.
+		hls.destroy();
+		video.removeAttribute('src');
+		video.load();
+	});
+	hls.loadSource(source.src);
+	hls.attachMedia(video);
+};
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/viewscreen/viewscreen/internal/rss"
+)
+
+// The number of seen items remembered per feed.
+var subscriptionSeenLimit = 1000
+
+// Subscription is an RSS or Atom feed that is checked for new torrents.
+type Subscription struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Include  string `json:"include"`
+	Exclude  string `json:"exclude"`
+	MinSize  int64  `json:"min_size"`
+	MaxSize  int64  `json:"max_size"`
+	Interval int    `json:"interval"` // minutes
+
+	Seen        []string  `json:"seen"`
+	LastChecked time.Time `json:"last_checked"`
+	LastFetched time.Time `json:"last_fetched"` // last successful fetch
+	LastError   string    `json:"last_error"`
+}
+
+// Due returns true when the feed should be checked again.
+func (s Subscription) Due() bool {
+	return time.Since(s.LastChecked) >= time.Duration(s.Interval)*time.Minute
+}
+
+// Match returns true if the item passes the feed's rules.
+func (s Subscription) Match(item rss.Item) bool {
+	if s.Include != "" {
+		if re, err := regexp.Compile("(?i)" + s.Include); err != nil || !re.MatchString(item.Title) {
+			return false
+		}
+	}
+	if s.Exclude != "" {
+		if re, err := regexp.Compile("(?i)" + s.Exclude); err != nil || re.MatchString(item.Title) {
+			return false
+		}
+	}
+	// Items of unknown size pass the size limits.
+	if item.Size > 0 {
+		if s.MinSize > 0 && item.Size < s.MinSize {
+			return false
+		}
+		if s.MaxSize > 0 && item.Size > s.MaxSize {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Subscription) seen(id string) bool {
+	for _, seen := range s.Seen {
+		if seen == id {
+			return true
+		}
+	}
+	return false
+}
+
+type Subscriptions struct {
+	sync.RWMutex
+	filename string
+
+	Items []Subscription `json:"subscriptions"`
+}
+
+func NewSubscriptions(filename string) (*Subscriptions, error) {
+	filename = filepath.Join(downloadDir, filename)
+	s := &Subscriptions{filename: filename}
+	b, err := ioutil.ReadFile(filename)
+
+	// Default for new subscriptions
+	if os.IsNotExist(err) {
+		return s, s.Save()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Open existing subscriptions
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+
+	// Feeds checked before successful fetches were tracked count as fetched if the check went fine.
+	for i, sub := range s.Items {
+		if sub.LastFetched.IsZero() && !sub.LastChecked.IsZero() && sub.LastError == "" {
+			s.Items[i].LastFetched = sub.LastChecked
+		}
+	}
+	return s, nil
+}
+
+func (s *Subscriptions) List() []Subscription {
+	s.RLock()
+	defer s.RUnlock()
+
+	subs := make([]Subscription, len(s.Items))
+	copy(subs, s.Items)
+	return subs
+}
+
+func (s *Subscriptions) Add(sub Subscription) error {
+	if sub.URL == "" {
+		return fmt.Errorf("missing feed URL")
+	}
+	if _, err := regexp.Compile(sub.Include); err != nil {
+		return fmt.Errorf("invalid include pattern: %s", err)
+	}
+	if _, err := regexp.Compile(sub.Exclude); err != nil {
+		return fmt.Errorf("invalid exclude pattern: %s", err)
+	}
+	if sub.Interval < 5 {
+		sub.Interval = 5
+	}
+	if sub.Name == "" {
+		sub.Name = sub.URL
+	}
+	sub.ID = fmt.Sprintf("%x", md5.Sum([]byte(sub.URL)))
+
+	s.Lock()
+	for _, existing := range s.Items {
+		if existing.ID == sub.ID {
+			s.Unlock()
+			return fmt.Errorf("feed already exists")
+		}
+	}
+	s.Items = append(s.Items, sub)
+	s.Unlock()
+	return s.Save()
+}
+
+func (s *Subscriptions) Remove(id string) error {
+	s.Lock()
+	var keep []Subscription
+	for _, sub := range s.Items {
+		if sub.ID == id {
+			continue
+		}
+		keep = append(keep, sub)
+	}
+	s.Items = keep
+	s.Unlock()
+	return s.Save()
+}
+
+func (s *Subscriptions) Save() error {
+	s.RLock()
+	defer s.RUnlock()
+
+	b, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	return Overwrite(s.filename, b, 0644)
+}
+
+// Poll checks the feeds that are due, forever.
+func (s *Subscriptions) Poll() {
+	for {
+		for _, sub := range s.List() {
+			if !sub.Due() {
+				continue
+			}
+			s.check(sub)
+		}
+		time.Sleep(1 * time.Minute)
+	}
+}
+
+// check fetches a feed and starts transfers for new matching items.
+// Items already in the feed when it's first fetched successfully are only marked as seen.
+// Items whose transfer fails to start aren't marked, so they're tried again next time.
+func (s *Subscriptions) check(sub Subscription) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	logger.Debugf("checking feed %q", sub.Name)
+	items, err := rss.Fetch(ctx, sub.URL)
+
+	var seen []string
+	var lastError string
+	if err != nil {
+		logger.Errorf("feed %q: %s", sub.Name, err)
+		lastError = err.Error()
+	}
+	for _, item := range items {
+		if sub.seen(item.ID) {
+			continue
+		}
+		if sub.LastFetched.IsZero() || !sub.Match(item) {
+			seen = append(seen, item.ID)
+			continue
+		}
+		logger.Infof("feed %q: starting transfer %q", sub.Name, item.Title)
+		if err := StartTransfer(item.Link, ""); err != nil {
+			logger.Errorf("feed %q: %q: %s", sub.Name, item.Title, err)
+			lastError = err.Error()
+			continue
+		}
+		seen = append(seen, item.ID)
+	}
+
+	s.Lock()
+	for i := range s.Items {
+		if s.Items[i].ID != sub.ID {
+			continue
+		}
+		all := append(s.Items[i].Seen, seen...)
+		if len(all) > subscriptionSeenLimit {
+			all = all[len(all)-subscriptionSeenLimit:]
+		}
+		s.Items[i].Seen = all
+		s.Items[i].LastChecked = time.Now()
+		if err == nil {
+			s.Items[i].LastFetched = s.Items[i].LastChecked
+		}
+		s.Items[i].LastError = lastError
+	}
+	s.Unlock()
+
+	if err := s.Save(); err != nil {
+		logger.Error(err)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token scopes, from least to most access.
+const (
+	ScopeRead      = "read"      // look, but don't change anything
+	ScopeTransfers = "transfers" // read, and manage transfers and transcodes
+	ScopeAdmin     = "admin"     // everything
+)
+
+var TokenScopes = []string{ScopeRead, ScopeTransfers, ScopeAdmin}
+
+// Last-used times are only saved this often, so tokens used by every request don't rewrite the file.
+var tokenSaveInterval = 1 * time.Minute
+
+// Token is a named API token. Only the hash of the secret is stored.
+type Token struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Hash     string    `json:"hash"`
+	Scope    string    `json:"scope"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// scopeRoutes are the routes each scope allows on top of the scopes before it, as
+// "METHOD path" under the prefix. A path ending in "*" matches everything below it.
+// Routes are listed rather than judged by method, so a route that changes something
+// stays closed to a token whatever method it's registered with. Anything not listed
+// needs the admin scope.
+var scopeRoutes = map[string][]string{
+	ScopeRead: {
+		"GET /",
+		"GET /help",
+		"GET /downloads/list",
+		"GET /downloads/files/*",
+		"GET /downloads/view/*",
+		"GET /downloads/save/*",
+		"GET /downloads/stream/*",
+		"GET /downloads/hls/*",
+		"GET /transfers/view/*",
+		"GET /transfers/stream/*",
+		"GET /v1/downloads",
+		"GET /v1/downloads/files/*",
+		"GET /v1/downloads/stream/*",
+		"GET /api/openapi.json",
+		"GET /api/downloads",
+		"GET /api/downloads/*",
+	},
+	ScopeTransfers: {
+		"GET /import",
+		"GET /transfers/list",
+		"GET /transfers/files/*",
+		"POST /transfers/*",
+		"GET /transfers/seed/*",
+		"GET /transcodes",
+		"GET /transcodes/list",
+		"POST /transcodes/clear",
+		"POST /transcode/*",
+		"GET /v1/transfers/files/*",
+		"POST /v1/transfers/files/*",
+		"GET /api/transfers",
+		"POST /api/transfers",
+		"GET /api/transfers/*",
+		"POST /api/transfers/*",
+		"DELETE /api/transfers/*",
+		"GET /api/transcodes",
+		"GET /api/transcodes/*",
+		"POST /api/transcodes/*",
+		"DELETE /api/transcodes/*",
+	},
+}
+
+// Allows returns true if the token's scope permits the request.
+func (t Token) Allows(r *http.Request) bool {
+	var scopes []string
+	switch t.Scope {
+	case ScopeAdmin:
+		return true
+	case ScopeTransfers:
+		scopes = []string{ScopeRead, ScopeTransfers}
+	case ScopeRead:
+		scopes = []string{ScopeRead}
+	}
+
+	method := r.Method
+	if method == "HEAD" {
+		method = "GET"
+	}
+	rel := strings.TrimPrefix(path.Clean(r.URL.Path), Prefix(""))
+	if rel == "" {
+		rel = "/"
+	}
+	for _, scope := range scopes {
+		for _, route := range scopeRoutes[scope] {
+			fields := strings.SplitN(route, " ", 2)
+			if fields[0] != method {
+				continue
+			}
+			if prefix := strings.TrimSuffix(fields[1], "*"); prefix != fields[1] {
+				if strings.HasPrefix(rel, prefix) {
+					return true
+				}
+			} else if rel == fields[1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type Tokens struct {
+	sync.RWMutex
+	filename string
+	saved    time.Time
+
+	Items []Token `json:"tokens"`
+}
+
+func NewTokens(filename string) (*Tokens, error) {
+	filename = filepath.Join(downloadDir, filename)
+	t := &Tokens{filename: filename}
+	b, err := ioutil.ReadFile(filename)
+
+	// Default for new tokens
+	if os.IsNotExist(err) {
+		return t, t.Save()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Open existing tokens
+	if err := json.Unmarshal(b, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Tokens) List() []Token {
+	t.RLock()
+	defer t.RUnlock()
+
+	tokens := make([]Token, len(t.Items))
+	copy(tokens, t.Items)
+	return tokens
+}
+
+// Create adds a token and returns its secret, which is not stored and can't be shown again.
+func (t *Tokens) Create(name, scope string) (Token, string, error) {
+	if name == "" {
+		return Token{}, "", fmt.Errorf("missing token name")
+	}
+	known := false
+	for _, s := range TokenScopes {
+		if scope == s {
+			known = true
+		}
+	}
+	if !known {
+		return Token{}, "", fmt.Errorf("unknown scope %q", scope)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return Token{}, "", err
+	}
+	secret := "vs_" + hex.EncodeToString(b)
+	hash := tokenHash(secret)
+	token := Token{
+		ID:      hash[:16],
+		Name:    name,
+		Hash:    hash,
+		Scope:   scope,
+		Created: time.Now(),
+	}
+
+	t.Lock()
+	for _, existing := range t.Items {
+		if existing.Name == name {
+			t.Unlock()
+			return Token{}, "", fmt.Errorf("a token named %q already exists", name)
+		}
+	}
+	t.Items = append(t.Items, token)
+	t.Unlock()
+	return token, secret, t.Save()
+}
+
+func (t *Tokens) Revoke(id string) error {
+	t.Lock()
+	var keep []Token
+	for _, token := range t.Items {
+		if token.ID == id {
+			continue
+		}
+		keep = append(keep, token)
+	}
+	t.Items = keep
+	t.Unlock()
+	return t.Save()
+}
+
+// Authenticate returns the token with the secret, and records that it was used.
+func (t *Tokens) Authenticate(secret string) (Token, bool) {
+	hash := tokenHash(secret)
+
+	t.Lock()
+	var token Token
+	found := false
+	for i := range t.Items {
+		if subtle.ConstantTimeCompare([]byte(t.Items[i].Hash), []byte(hash)) == 1 {
+			t.Items[i].LastUsed = time.Now()
+			token = t.Items[i]
+			found = true
+		}
+	}
+	save := found && time.Since(t.saved) >= tokenSaveInterval
+	t.Unlock()
+
+	if save {
+		if err := t.Save(); err != nil {
+			logger.Errorf("saving tokens failed: %s", err)
+		}
+	}
+	return token, found
+}
+
+func (t *Tokens) Save() error {
+	t.Lock()
+	defer t.Unlock()
+
+	b, err := json.MarshalIndent(t, "", "    ")
+	if err != nil {
+		return err
+	}
+	t.saved = time.Now()
+	return Overwrite(t.filename, b, 0600)
+}
+
+func tokenHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header, if any.
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+}
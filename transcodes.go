@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Only the most recent transcode jobs are kept in the history.
+var transcodeHistoryLimit = 200
+
+// TranscodeRecord is a finished, failed or canceled transcode job.
+type TranscodeRecord struct {
+	Download string    `json:"download"`
+	File     string    `json:"file"`
+	Output   string    `json:"output,omitempty"` // the new file, relative to the download
+	Profile  string    `json:"profile,omitempty"`
+	Mode     string    `json:"mode,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Canceled bool      `json:"canceled,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Log      string    `json:"log,omitempty"` // the end of ffmpeg's output, if it failed
+}
+
+func (r TranscodeRecord) Elapsed() time.Duration {
+	return r.Finished.Sub(r.Started)
+}
+
+// State returns "finished", "failed" or "canceled".
+func (r TranscodeRecord) State() string {
+	switch {
+	case r.Canceled:
+		return "canceled"
+	case r.Error != "":
+		return "failed"
+	}
+	return "finished"
+}
+
+type TranscodeHistory struct {
+	sync.RWMutex
+	filename string
+
+	Items []TranscodeRecord `json:"transcodes"`
+}
+
+func NewTranscodeHistory(filename string) (*TranscodeHistory, error) {
+	filename = filepath.Join(downloadDir, filename)
+	h := &TranscodeHistory{filename: filename}
+	b, err := ioutil.ReadFile(filename)
+
+	// Default for new history
+	if os.IsNotExist(err) {
+		return h, h.Save()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Open existing history
+	if err := json.Unmarshal(b, h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// List returns the history, most recent first.
+func (h *TranscodeHistory) List() []TranscodeRecord {
+	h.RLock()
+	defer h.RUnlock()
+
+	records := make([]TranscodeRecord, len(h.Items))
+	for i, record := range h.Items {
+		records[len(h.Items)-1-i] = record
+	}
+	return records
+}
+
+func (h *TranscodeHistory) Add(record TranscodeRecord) error {
+	h.Lock()
+	h.Items = append(h.Items, record)
+	if n := len(h.Items) - transcodeHistoryLimit; n > 0 {
+		h.Items = h.Items[n:]
+	}
+	h.Unlock()
+	return h.Save()
+}
+
+func (h *TranscodeHistory) Clear() error {
+	h.Lock()
+	h.Items = nil
+	h.Unlock()
+	return h.Save()
+}
+
+func (h *TranscodeHistory) Save() error {
+	h.RLock()
+	defer h.RUnlock()
+
+	b, err := json.MarshalIndent(h, "", "    ")
+	if err != nil {
+		return err
+	}
+	return Overwrite(h.filename, b, 0644)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrTrashNotFound = errors.New("trash item not found")
+
+var trashInterval = 1 * time.Hour
+
+// TrashItem is a removed download that can still be restored.
+type TrashItem struct {
+	ID       string    `json:"id"`
+	Download string    `json:"download"`
+	Removed  time.Time `json:"removed"`
+	Size     int64     `json:"size"`
+	Shared   bool      `json:"shared"`
+	User     string    `json:"user,omitempty"`
+}
+
+func trashDir() string {
+	return filepath.Join(downloadDir, ".trash")
+}
+
+func (item TrashItem) Path() string {
+	return filepath.Join(trashDir(), item.ID)
+}
+
+func (item TrashItem) Metafile() string {
+	return item.Path() + ".json"
+}
+
+// Expires returns when the item is purged automatically, or the zero time if never.
+func (item TrashItem) Expires() time.Time {
+	days := config.Get().TrashDays
+	if days <= 0 {
+		return time.Time{}
+	}
+	return item.Removed.Add(time.Duration(days) * 24 * time.Hour)
+}
+
+// TrashDownload moves the download into the trash, recording the user who removed it.
+func TrashDownload(dl Download, user string) (TrashItem, error) {
+	now := time.Now()
+	item := TrashItem{
+		ID:       fmt.Sprintf("%x", md5.Sum([]byte(dl.ID+now.String()))),
+		Download: dl.ID,
+		Removed:  now,
+		Size:     dl.Size(),
+		Shared:   dl.Shared(),
+		User:     user,
+	}
+	if err := os.MkdirAll(trashDir(), 0755); err != nil {
+		return TrashItem{}, err
+	}
+	b, err := json.MarshalIndent(item, "", "    ")
+	if err != nil {
+		return TrashItem{}, err
+	}
+	if err := Overwrite(item.Metafile(), b, 0644); err != nil {
+		return TrashItem{}, err
+	}
+	if err := os.Rename(dl.Path(), item.Path()); err != nil {
+		os.Remove(item.Metafile())
+		return TrashItem{}, err
+	}
+	if err := dl.Unshare(); err != nil {
+		logger.Warnf("trash: unsharing %q failed: %s", dl.ID, err)
+	}
+	logger.Infof("trash: %q moved %q to the trash", user, dl.ID)
+	return item, nil
+}
+
+func ListTrash() ([]TrashItem, error) {
+	list, err := ioutil.ReadDir(trashDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var items []TrashItem
+	for _, fi := range list {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(trashDir(), fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var item TrashItem
+		if err := json.Unmarshal(b, &item); err != nil {
+			logger.Warnf("trash: skipping %q: %s", fi.Name(), err)
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Removed.After(items[j].Removed) })
+	return items, nil
+}
+
+func FindTrash(id string) (TrashItem, error) {
+	items, err := ListTrash()
+	if err != nil {
+		return TrashItem{}, err
+	}
+	for _, item := range items {
+		if item.ID == id {
+			return item, nil
+		}
+	}
+	return TrashItem{}, ErrTrashNotFound
+}
+
+// RestoreTrash moves the item back into the library, logging the user who restored it.
+func RestoreTrash(id, user string) (Download, error) {
+	item, err := FindTrash(id)
+	if err != nil {
+		return Download{}, err
+	}
+	dl := Download{ID: item.Download}
+	if _, err := os.Stat(dl.Path()); err == nil {
+		return Download{}, fmt.Errorf("a download named %q already exists", dl.ID)
+	}
+	if err := os.Rename(item.Path(), dl.Path()); err != nil {
+		return Download{}, err
+	}
+	if err := os.Remove(item.Metafile()); err != nil {
+		return Download{}, err
+	}
+	if item.Shared {
+		if err := dl.Share(); err != nil {
+			logger.Warnf("trash: sharing %q failed: %s", dl.ID, err)
+		}
+	}
+	logger.Infof("trash: %q restored %q", user, dl.ID)
+	return dl, nil
+}
+
+// PurgeTrash deletes the item permanently, logging the user who purged it.
+func PurgeTrash(id, user string) error {
+	item, err := FindTrash(id)
+	if err != nil {
+		return err
+	}
+	return purge(item, user)
+}
+
+func purge(item TrashItem, user string) error {
+	if err := os.RemoveAll(item.Path()); err != nil {
+		return err
+	}
+	if err := os.Remove(item.Metafile()); err != nil {
+		return err
+	}
+
+	// Forget when it was watched, unless it was downloaded again since.
+	dl := Download{ID: item.Download}
+	if _, err := os.Stat(dl.Path()); os.IsNotExist(err) {
+		os.Remove(dl.Watchedfile())
+	}
+	logger.Infof("trash: %q purged %q", user, item.Download)
+	return nil
+}
+
+// EmptyTrash deletes everything in the trash permanently.
+func EmptyTrash(user string) error {
+	items, err := ListTrash()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := purge(item, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeTrash deletes items that have been in the trash longer than the configured age.
+func purgeTrash() {
+	for {
+		items, err := ListTrash()
+		if err != nil {
+			logger.Errorf("trash: %s", err)
+		}
+		now := time.Now()
+		for _, item := range items {
+			if expires := item.Expires(); expires.IsZero() || now.Before(expires) {
+				continue
+			}
+			if err := purge(item, "expired"); err != nil {
+				logger.Errorf("trash: purging %q failed: %s", item.Download, err)
+			}
+		}
+		time.Sleep(trashInterval)
+	}
+}
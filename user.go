@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User roles, from least to most access.
+const (
+	RoleViewer = "viewer" // browse and stream
+	RoleMember = "member" // also add and manage transfers, transcodes and downloads
+	RoleAdmin  = "admin"  // also manage friends, settings and users
+)
+
+var Roles = []string{RoleViewer, RoleMember, RoleAdmin}
+
+var validUsername = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+// roleRank orders the roles; unknown roles have no access.
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// HasRole returns true if the role includes the access of the required role.
+func HasRole(role, required string) bool {
+	return roleRank(role) >= roleRank(required)
+}
+
+// Require only lets users with the role, or a higher one, through to the handler.
+// It must be wrapped by Auth, which sets the "role" param.
+func Require(role string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if !HasRole(ps.ByName("role"), role) {
+			logger.Errorf("auth: user %q with role %q needs %q for %s %q", ps.ByName("user"), ps.ByName("role"), role, r.Method, r.URL.Path)
+			if apiRequest(r) {
+				apiError(w, http.StatusForbidden, fmt.Errorf("this requires the %s role", role))
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h(w, r, ps)
+	}
+}
+
+// User is an account. Only the bcrypt hash of the password is stored.
+type User struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Role    string    `json:"role"`
+	Created time.Time `json:"created"`
+}
+
+type Users struct {
+	sync.RWMutex
+	filename string
+
+	Items []User `json:"users"`
+}
+
+func NewUsers(filename string) (*Users, error) {
+	filename = filepath.Join(downloadDir, filename)
+	u := &Users{filename: filename}
+	b, err := ioutil.ReadFile(filename)
+
+	// Default for new users
+	if os.IsNotExist(err) {
+		return u, u.Save()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Open existing users
+	if err := json.Unmarshal(b, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (u *Users) List() []User {
+	u.RLock()
+	defer u.RUnlock()
+
+	users := make([]User, len(u.Items))
+	copy(users, u.Items)
+	return users
+}
+
+// Get returns the user with the ID.
+func (u *Users) Get(id string) (User, bool) {
+	u.RLock()
+	defer u.RUnlock()
+	for _, user := range u.Items {
+		if user.ID == id {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// Find returns the user with the name.
+func (u *Users) Find(name string) (User, bool) {
+	u.RLock()
+	defer u.RUnlock()
+	for _, user := range u.Items {
+		if user.Name == name {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+func (u *Users) Add(name, password, role string) error {
+	if !validUsername.MatchString(name) {
+		return fmt.Errorf("user names can only have letters, numbers, dots, dashes and underscores")
+	}
+	if name == httpUsername {
+		return fmt.Errorf("%q is the built-in admin account", name)
+	}
+	if roleRank(role) == 0 {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	user := User{
+		ID:      fmt.Sprintf("%x", md5.Sum([]byte(name))),
+		Name:    name,
+		Hash:    hash,
+		Role:    role,
+		Created: time.Now(),
+	}
+
+	u.Lock()
+	for _, existing := range u.Items {
+		if existing.ID == user.ID {
+			u.Unlock()
+			return fmt.Errorf("user already exists")
+		}
+	}
+	u.Items = append(u.Items, user)
+	u.Unlock()
+	return u.Save()
+}
+
+func (u *Users) Remove(id string) error {
+	u.Lock()
+	var keep []User
+	for _, user := range u.Items {
+		if user.ID == id {
+			continue
+		}
+		keep = append(keep, user)
+	}
+	u.Items = keep
+	u.Unlock()
+	return u.Save()
+}
+
+func (u *Users) SetRole(id, role string) error {
+	if roleRank(role) == 0 {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	return u.update(id, func(user *User) error {
+		user.Role = role
+		return nil
+	})
+}
+
+func (u *Users) SetPassword(id, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return u.update(id, func(user *User) error {
+		user.Hash = hash
+		return nil
+	})
+}
+
+func (u *Users) update(id string, fn func(*User) error) error {
+	u.Lock()
+	found := false
+	for i := range u.Items {
+		if u.Items[i].ID != id {
+			continue
+		}
+		if err := fn(&u.Items[i]); err != nil {
+			u.Unlock()
+			return err
+		}
+		found = true
+	}
+	u.Unlock()
+	if !found {
+		return fmt.Errorf("user not found")
+	}
+	return u.Save()
+}
+
+// Authenticate returns the user if the password matches.
+func (u *Users) Authenticate(name, password string) (User, bool) {
+	user, ok := u.Find(name)
+	if !ok {
+		return User{}, false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(password)); err != nil {
+		return User{}, false
+	}
+	return user, true
+}
+
+func (u *Users) Save() error {
+	u.RLock()
+	defer u.RUnlock()
+
+	b, err := json.MarshalIndent(u, "", "    ")
+	if err != nil {
+		return err
+	}
+	return Overwrite(u.filename, b, 0600)
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < 8 {
+		return "", fmt.Errorf("passwords must be at least 8 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// tokenRole is the role an API token acts with.
+func tokenRole(scope string) string {
+	switch scope {
+	case ScopeAdmin:
+		return RoleAdmin
+	case ScopeTransfers:
+		return RoleMember
+	}
+	return RoleViewer
+}
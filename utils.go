@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -138,3 +139,66 @@ func Overwrite(filename string, data []byte, perm os.FileMode) error {
 	}
 	return os.Rename(f.Name(), filename)
 }
+
+// moveDir moves a directory, copying it when the destination is on another filesystem.
+func moveDir(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%q already exists", dst)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	// Copy into a temporary directory first, so a failed copy leaves no partial download behind.
+	tmpdir, err := ioutil.TempDir(filepath.Dir(dst), ".tmpmove")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(tmpdir, rel)
+		if info.IsDir() {
+			if err := os.MkdirAll(target, info.Mode()); err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode())
+		}
+		return copyFile(path, target, info.Mode())
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpdir, dst); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/viewscreen/viewscreen/internal/downloader"
+	"github.com/viewscreen/viewscreen/internal/transcoder"
 )
 
 var ErrDownloadNotFound = errors.New("download not found")
@@ -74,35 +77,208 @@ func ListTransfersPending() []downloader.Transfer {
 	return dler.ListPending()
 }
 
-func StartTransfer(target string) error {
-	_, err := dler.Add(target)
+// StartTransfer queues the target, recording the user who added it (optional).
+func StartTransfer(target, user string) error {
+	_, err := dler.Add(target, user)
 	return err
 }
 
-func CancelTransfer(id string) error {
-	return dler.Remove(id)
+func StreamTransferFile(ctx context.Context, id string, index int) (*downloader.FileReader, error) {
+	return dler.StreamFile(ctx, id, index)
+}
+
+func AddTransfer(target, user string) (downloader.Transfer, error) {
+	return dler.Add(target, user)
+}
+
+func AddTorrentTransfer(r io.Reader, user string) (downloader.Transfer, error) {
+	return dler.AddTorrent(r, user)
+}
+
+func StartTorrentTransfer(r io.Reader, user string) error {
+	_, err := dler.AddTorrent(r, user)
+	return err
+}
+
+// CancelTransfer removes the transfer, logging the user who canceled it.
+func CancelTransfer(id, user string) error {
+	if err := dler.Remove(id); err != nil {
+		return err
+	}
+	logger.Infof("transfer %q canceled by %q", id, user)
+	return nil
+}
+
+func PauseTransfer(id string) error {
+	return dler.Pause(id)
+}
+
+func ResumeTransfer(id string) error {
+	return dler.Resume(id)
+}
+
+func MoveTransferTop(id string) error {
+	return dler.MoveTop(id)
+}
+
+func MoveTransferBottom(id string) error {
+	return dler.MoveBottom(id)
+}
+
+func SetTransferSeedRules(id string, rules downloader.SeedRules) error {
+	return dler.SetSeedRules(id, rules)
+}
+
+func SetTransferPriority(id string, priority int) error {
+	return dler.SetPriority(id, priority)
 }
 
 func FindTransfer(id string) (downloader.Transfer, error) {
 	return dler.Find(id)
 }
 
+func ListTransferFiles(id string) ([]downloader.TransferFile, error) {
+	return dler.TorrentFiles(id)
+}
+
+func SetTransferFiles(id string, priorities map[int]int) error {
+	return dler.SetFilePriorities(id, priorities)
+}
+
 //
 // Transcoding
 //
 
-func StartTranscode(path string) error {
-	return tcer.Add(path)
+// StartTranscode queues the file to be transcoded with the profile, or the default profile if it's "".
+func StartTranscode(path, profile string) error {
+	cfg := config.Get()
+	p, err := cfg.FindProfile(profile)
+	if err != nil {
+		return err
+	}
+	return addTranscode(path, p, false)
+}
+
+// CancelTranscode cancels the transcode of the file with the profile, or with every profile if it's "".
+func CancelTranscode(path, profile string) error {
+	for _, job := range tcer.Jobs() {
+		if job.Src == path && (profile == "" || job.Profile == profile) {
+			dler.Release(transcodeReservation(path, job.Profile))
+		}
+	}
+	return tcer.Cancel(path, profile)
+}
+
+// addTranscode queues a transcode after reserving storage for its output,
+// which is no bigger than the source.
+func addTranscode(path string, profile transcoder.Profile, keep bool) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	reservation := transcodeReservation(path, profile.ID())
+	if err := dler.Reserve(reservation, fi.Size()); err != nil {
+		return err
+	}
+	if err := tcer.Add(path, profile, keep); err != nil {
+		dler.Release(reservation)
+		return err
+	}
+	return nil
 }
 
-func CancelTranscode(path string) error {
-	return tcer.Cancel(path)
+func transcodeReservation(path, profile string) string {
+	return "transcode:" + profile + ":" + path
 }
 
 func ActiveTranscode(path string) bool {
 	return tcer.Active(path)
 }
 
+// TranscodeMode returns how the running transcode of the file converts it, or "" if it isn't running.
+func TranscodeMode(path string) string {
+	return tcer.Mode(path)
+}
+
+// TranscodeProgress returns how far along the running transcode of the file is.
+func TranscodeProgress(path string) transcoder.Progress {
+	progress, _ := tcer.Progress(path)
+	return progress
+}
+
+// TranscodeJob is a running or queued transcode.
+type TranscodeJob struct {
+	Download string
+	File     string
+	Profile  string
+	State    string // "running" or "queued"
+	Mode     string
+	Progress transcoder.Progress
+}
+
+// ListTranscodeJobs returns the running transcodes, followed by the queued ones.
+func ListTranscodeJobs() []TranscodeJob {
+	var jobs []TranscodeJob
+	for _, status := range tcer.Jobs() {
+		id, file, ok := splitDownloadPath(status.Src)
+		if !ok {
+			continue
+		}
+		jobs = append(jobs, TranscodeJob{
+			Download: id,
+			File:     file,
+			Profile:  status.Profile,
+			State:    status.State,
+			Mode:     status.Mode,
+			Progress: status.Progress,
+		})
+	}
+	return jobs
+}
+
+func ListTranscodeHistory() []TranscodeRecord {
+	return transcodeHistory.List()
+}
+
+// recordTranscode adds a finished transcode job to the history.
+func recordTranscode(res transcoder.Result) {
+	id, file, ok := splitDownloadPath(res.Src)
+	if !ok {
+		return
+	}
+	record := TranscodeRecord{
+		Download: id,
+		File:     file,
+		Profile:  res.Profile,
+		Mode:     res.Mode,
+		Started:  res.Started,
+		Finished: res.Finished,
+		Canceled: res.Canceled,
+	}
+	if res.Err != nil {
+		record.Error = res.Err.Error()
+		record.Log = res.Output
+	} else if _, output, ok := splitDownloadPath(res.Dst); ok {
+		record.Output = output
+	}
+	if err := transcodeHistory.Add(record); err != nil {
+		logger.Errorf("transcode history: %s", err)
+	}
+}
+
+// splitDownloadPath returns the download and file IDs of a path in the download directory.
+func splitDownloadPath(path string) (id, file string, ok bool) {
+	rel, err := filepath.Rel(downloadDir, path)
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.SplitN(rel, string(filepath.Separator), 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
 //
 // Friends
 //
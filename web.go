@@ -7,6 +7,7 @@ import (
 	"html/template"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -24,6 +25,9 @@ type Response struct {
 	Error      string
 	Backlink   string
 	User       string
+	Role       string
+	Session    bool
+	CSRF       string
 	FeedSecret string
 
 	DiskInfo *DiskInfo
@@ -42,12 +46,29 @@ type Response struct {
 	Transfer         downloader.Transfer
 	Transfers        []downloader.Transfer
 	TransfersPending []downloader.Transfer
+	TransferFiles    []downloader.TransferFile
+	TransferFile     downloader.TransferFile
 
 	Sort  string
 	Query string
 
 	Results []search.Result
 
+	Subscriptions []Subscription
+
+	Tokens   *Tokens
+	NewToken string
+
+	Users []User
+
+	TranscodeJobs    []TranscodeJob
+	TranscodeHistory []TranscodeRecord
+
+	Trash []TrashItem
+
+	Retention        []RetentionItem
+	RetentionApplied bool
+
 	Version string
 
 	Config *Config
@@ -73,7 +94,26 @@ var (
 			return fmt.Sprintf("%.2f GB", float64(n)/1024/1024/1024)
 			// return humanize.Bytes(uint64(n))
 		},
-		"time": humanize.Time,
+		"time":     humanize.Time,
+		"viewable": viewable,
+		"minutes": func(d time.Duration) int64 {
+			return int64(d / time.Minute)
+		},
+		"duration": func(d time.Duration) string {
+			d = d.Round(time.Second)
+			h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+			if h > 0 {
+				return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+			}
+			return fmt.Sprintf("%d:%02d", m, s)
+		},
+		"bitrate": func(bps int64) string {
+			if bps >= 1000*1000 {
+				return fmt.Sprintf("%.1f Mbps", float64(bps)/1000/1000)
+			}
+			return fmt.Sprintf("%d kbps", bps/1000)
+		},
+		"join": strings.Join,
 		"truncate": func(s string, n int) string {
 			if len(s) > n {
 				s = s[:n-3] + "..."
@@ -102,15 +142,24 @@ func NewResponse(r *http.Request, ps httprouter.Params) *Response {
 	return &Response{
 		Request:    r,
 		User:       ps.ByName("user"),
+		Role:       ps.ByName("role"),
+		Session:    ps.ByName("session") != "",
+		CSRF:       ps.ByName("csrf"),
 		HTTPHost:   httpHost,
 		DiskInfo:   di,
 		FeedSecret: feedsecret.Get(),
 		Version:    version,
 		Backlink:   backlink,
 		Config:     config,
+		Tokens:     tokens,
 	}
 }
 
+// Can returns true if the user has the role, or a higher one.
+func (res Response) Can(role string) bool {
+	return HasRole(res.Role, role)
+}
+
 func Error(w http.ResponseWriter, err error) {
 	logger.Error(err)
 
@@ -187,6 +236,9 @@ func Auth(h httprouter.Handle, friends bool) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		failed := true
 		user := ""
+		role := ""
+		session := ""
+		csrf := ""
 
 		clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
 		if err != nil {
@@ -227,6 +279,7 @@ func Auth(h httprouter.Handle, friends bool) httprouter.Handle {
 						if addr == clientIP {
 							failed = false
 							user = host
+							role = RoleViewer
 							return
 						}
 
@@ -237,6 +290,7 @@ func Auth(h httprouter.Handle, friends bool) httprouter.Handle {
 								logger.Debugf("auth: friend match addr %q in xff %q", addr, xff)
 								failed = false
 								user = host
+								role = RoleViewer
 								return
 							}
 						}
@@ -245,16 +299,46 @@ func Auth(h httprouter.Handle, friends bool) httprouter.Handle {
 				return
 			}()
 
-		} else if reverseProxyAuthIP == "" {
-			// Auth Method: Basic Auth (if we're not behind a reverse proxy, use basic auth)
-			login, password, _ := r.BasicAuth()
+		} else if secret := bearerToken(r); secret != "" {
+			// Auth Method: API token (accepted with or without a reverse proxy)
+			token, ok := tokens.Authenticate(secret)
+			if ok {
+				if !token.Allows(r) {
+					logger.Errorf("auth: token %q scope %q doesn't allow %s %q", token.Name, token.Scope, r.Method, r.URL.Path)
+					if apiRequest(r) {
+						apiError(w, http.StatusForbidden, fmt.Errorf("token scope %q doesn't allow this request", token.Scope))
+						return
+					}
+					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+					return
+				}
+				failed = false
+				user = "token:" + token.Name
+				role = tokenRole(token.Scope)
+			}
+		} else if login, password, ok := r.BasicAuth(); ok && reverseProxyAuthIP == "" {
+			// Auth Method: Basic Auth (for scripts, if we're not behind a reverse proxy)
 			if login == httpUsername && password == authsecret.Get() {
 				failed = false
 				user = login
+				role = RoleAdmin
+			} else if u, ok := users.Authenticate(login, password); ok {
+				failed = false
+				user = u.Name
+				role = u.Role
 			} else {
-				w.Header().Set("WWW-Authenticate", `Basic realm="Login Required"`)
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-				return
+				time.Sleep(loginFailureDelay)
+			}
+		} else if reverseProxyAuthIP == "" {
+			// Auth Method: Session (if we're not behind a reverse proxy, log in on the login page)
+			if s, ok := sessions.Find(sessionSecret(r)); ok {
+				if current, ok := sessionRole(s.User); ok {
+					failed = false
+					user = s.User
+					role = current
+					session = s.ID
+					csrf = sessions.CSRF(s.ID)
+				}
 			}
 		} else {
 			// Method: Reverse Proxy (if we're behind a reverse proxy, trust it.)
@@ -262,12 +346,22 @@ func Auth(h httprouter.Handle, friends bool) httprouter.Handle {
 				if u := r.Header.Get(reverseProxyAuthHeader); u != "" {
 					failed = false
 					user = u
+					role = proxyRole(u)
+					csrf = sessions.CSRF("proxy:" + u)
 				}
 			}
 		}
 
 		if failed {
 			logger.Errorf("auth failed: client %q", clientIP)
+			if apiRequest(r) {
+				apiError(w, http.StatusUnauthorized, fmt.Errorf("authentication required"))
+				return
+			}
+			if reverseProxyAuthIP == "" {
+				Redirect(w, r, "/login?next=%s", url.QueryEscape(r.URL.RequestURI()))
+				return
+			}
 			if backlink != "" {
 				http.Redirect(w, r, backlink, http.StatusFound)
 				return
@@ -276,12 +370,39 @@ func Auth(h httprouter.Handle, friends bool) httprouter.Handle {
 			return
 		}
 
-		// Add "user" to params.
+		// Browsers send session cookies and proxy logins along with any request, even one
+		// made by another site, so changes must carry the CSRF token of the page they came from.
+		if csrf != "" && r.Method != "GET" && r.Method != "HEAD" && !validCSRF(r, csrf) {
+			logger.Errorf("auth: invalid CSRF token from user %q for %s %q", user, r.Method, r.URL.Path)
+			if apiRequest(r) {
+				apiError(w, http.StatusForbidden, fmt.Errorf("invalid CSRF token"))
+				return
+			}
+			http.Error(w, "Invalid CSRF token, reload the page and try again", http.StatusForbidden)
+			return
+		}
+
+		// Add "user", "role", "session" and "csrf" to params.
 		ps = append(ps, httprouter.Param{Key: "user", Value: user})
+		ps = append(ps, httprouter.Param{Key: "role", Value: role})
+		ps = append(ps, httprouter.Param{Key: "session", Value: session})
+		ps = append(ps, httprouter.Param{Key: "csrf", Value: csrf})
 		h(w, r, ps)
 	}
 }
 
+// proxyRole returns the role of a user named by the reverse proxy.
+// Without any accounts, everyone the proxy lets in is an admin, as before accounts existed.
+func proxyRole(name string) string {
+	if u, ok := users.Find(name); ok {
+		return u.Role
+	}
+	if name == httpUsername || len(users.List()) == 0 {
+		return RoleAdmin
+	}
+	return RoleViewer
+}
+
 func BaseURL(r *http.Request) string {
 	scheme := r.Header.Get("X-Forwarded-Proto")
 	if scheme == "" {